@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/webedx-spark/gloat"
 
@@ -23,16 +30,63 @@ const usage = `Usage gloat: [OPTION ...] [COMMAND ...]
 Gloat is a Go SQL migration utility.
 
 Commands:
-  new                      Create a new migration folder
-  up                       Apply new migrations
+  new [-layout single] <name>
+                           Create a new migration folder, or a
+                           single file
+  up [-tenants file] [-parallel N]
+                           Apply new migrations, to every schema or
+                           database URL listed in the tenants file,
+                           N at a time (default 1).
   down                     Revert the last applied migration
-  to <version>             Migrate to a given version (down to).
+  to <version>             Migrate up or down to a given version.
+  redo [-n N]              Revert and apply again the last N applied
+                           migrations (default 1).
   latest                   Latest migration in the source.
   current                  Latest Applied migration.
   present                  List all present versions.
-
-Options:
+  status                   Show the state of every migration.
+  history [version]        List every migration run, even reverted.
+  verify                   Check applied migrations for changed content.
+  force <version>          Mark a dirty migration as applied.
+  clean-dirty              Remove dirty migration marks.
+  baseline <version>       Mark the migrations up to a version as
+                           applied, without running them.
+  squash <version>         Replace the migrations up to a version
+                           with a single one.
+  mark <version>           Mark a migration as applied, without
+                           running it.
+  unmark <version>         Mark a migration as unapplied, without
+                           reverting it.
+
+Options, given before or after the command:
   -quiet        Output only errors
+  -dry-run      Print the statements of up, down, to and redo
+                instead of running them
+  -format       The status and history output format, table or
+                json
+                (default table)
+  -since        List the history from the given time on,
+                e.g. 2018-09-05 or 2018-09-05T12:00:00Z
+  -until        List the history before the given time
+  -force        Baseline even if migrations are already applied
+  -out-of-order What to do with unapplied migrations older than
+                the latest applied one: allow, warn or error
+                (default warn)
+  -up-only      Fail if to would revert migrations
+  -down-only    Fail if to would apply migrations
+  -lock-timeout How long to wait for the migration lock
+                (default 1m)
+  -timeout      Abort the command after the given duration
+                (default no timeout)
+  -table        The table applied migrations are recorded in
+                (default schema_migrations)
+  -schema       The schema of the migrations table
+                (default the one of the connection)
+  -tag          A deploy identifier recorded with the applied
+                migrations
+  -var          A key=value variable the migrations are rendered
+                with as templates, can be repeated
+  -var-file     A file of key=value variables, one per line
   -src          The folder with migrations
                 (default $DATABASE_SRC or database/migrations)
   -url          The database connection URL
@@ -41,10 +95,29 @@ Options:
 `
 
 type arguments struct {
-	url   string
-	src   string
-	quiet bool
-	rest  []string
+	url         string
+	src         string
+	quiet       bool
+	dryRun      bool
+	upOnly      bool
+	downOnly    bool
+	force       bool
+	outOfOrder  string
+	format      string
+	table       string
+	schema      string
+	tag         string
+	vars        varsFlag
+	varFile     string
+	since       string
+	until       string
+	lockTimeout time.Duration
+	timeout     time.Duration
+	tenants     string
+	parallel    int
+	n           int
+	layout      string
+	rest        []string
 }
 
 func main() {
@@ -55,70 +128,328 @@ func main() {
 		cmdName = args.rest[0]
 	}
 
+	ctx, cancel := setupContext(args)
+
 	var err error
 	switch cmdName {
 	case "up":
-		err = upCmd(args)
+		err = upCmd(ctx, args)
 	case "down":
-		err = downCmd(args)
+		err = downCmd(ctx, args)
 	case "new":
 		err = newCmd(args)
 	case "to":
-		err = migrateToCmd(args)
+		err = migrateToCmd(ctx, args)
+	case "redo":
+		err = redoCmd(ctx, args)
 	case "latest":
-		err = latestCmd(args)
+		err = latestCmd(ctx, args)
 	case "current":
-		err = currentCmd(args)
+		err = currentCmd(ctx, args)
 	case "present":
-		err = presentCmd(args)
+		err = presentCmd(ctx, args)
+	case "status":
+		err = statusCmd(ctx, args)
+	case "history":
+		err = historyCmd(ctx, args)
+	case "verify":
+		err = verifyCmd(ctx, args)
+	case "force":
+		err = forceCmd(ctx, args)
+	case "clean-dirty":
+		err = cleanDirtyCmd(ctx, args)
+	case "baseline":
+		err = baselineCmd(ctx, args)
+	case "squash":
+		err = squashCmd(ctx, args)
+	case "mark":
+		err = markCmd(ctx, args)
+	case "unmark":
+		err = unmarkCmd(ctx, args)
 	default:
 		fmt.Fprintf(os.Stderr, usage)
 		os.Exit(2)
 	}
 
+	cancel()
+
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %+v\n", err)
+		printError(err)
 		os.Exit(2)
 	}
 }
 
-func upCmd(args arguments) error {
+// printError prints a command error to stderr. Migration errors are printed
+// with the migration and the failing statement, if known.
+func printError(err error) {
+	var migrationErr gloat.MigrationError
+	if !errors.As(err, &migrationErr) {
+		fmt.Fprintf(os.Stderr, "Error: %+v\n", err)
+		return
+	}
+
+	rolledBack := "no, inspect the database"
+	if migrationErr.RolledBack {
+		rolledBack = "yes"
+	}
+
+	cause := migrationErr.Err
+
+	var statementErr gloat.StatementError
+	if errors.As(cause, &statementErr) {
+		cause = statementErr.Err
+	}
+
+	fmt.Fprintf(os.Stderr, "Error: migration %d failed going %s\n", migrationErr.Version, migrationErr.Direction)
+	fmt.Fprintf(os.Stderr, "  Path:        %s\n", migrationErr.Path)
+	if statement := migrationErr.Statement; statement != nil {
+		fmt.Fprintf(os.Stderr, "  Statement:   %d, line %d, offset %d\n", statementErr.Index+1, statement.Line, statement.Offset)
+	}
+	fmt.Fprintf(os.Stderr, "  Rolled back: %s\n", rolledBack)
+	fmt.Fprintf(os.Stderr, "  Cause:       %+v\n", cause)
+
+	if statement := migrationErr.Statement; statement != nil {
+		fmt.Fprintf(os.Stderr, "\n%s\n", statement.SQL)
+	}
+}
+
+// setupContext creates the context commands run in. It is cancelled on
+// SIGINT and SIGTERM and after the -timeout, if given.
+func setupContext(args arguments) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if args.timeout <= 0 {
+		return ctx, stop
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, args.timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+func upCmd(ctx context.Context, args arguments) (err error) {
+	if err := checkArgs(args, 0); err != nil {
+		return err
+	}
+
+	if args.tenants != "" {
+		return upTenantsCmd(ctx, args)
+	}
+
 	gl, err := setupGloat(args)
 	if err != nil {
 		return err
 	}
 
-	migrations, err := gl.Unapplied()
+	if !args.dryRun {
+		if err := gl.LockContext(ctx); err != nil {
+			return err
+		}
+		defer unlock(gl, &err)
+	}
+
+	plan, err := gl.PlanUpContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if args.dryRun {
+		printPlan(plan)
+		return nil
+	}
+
+	if len(plan) == 0 {
+		printf(args, "No migrations to apply\n")
+		return nil
+	}
+
+	return executePlan(ctx, gl, args, plan)
+}
+
+func upTenantsCmd(ctx context.Context, args arguments) error {
+	targets, err := readTargets(args.tenants)
 	if err != nil {
 		return err
 	}
 
-	appliedMigrations := map[int64]bool{}
+	setup := tenantSetup(args)
 
-	for _, migration := range migrations {
-		printf(args, "Applying: %d...\n", migration.Version)
+	if args.dryRun {
+		for _, target := range targets {
+			fmt.Printf("-- Tenant %s\n", target)
+			if err := printTenantPlan(ctx, setup, target); err != nil {
+				return err
+			}
+		}
 
-		if err := gl.Apply(migration); err != nil {
-			return err
+		return nil
+	}
+
+	runner := gloat.TenantRunner{Targets: targets, Setup: setup, Parallel: args.parallel}
+
+	results, err := runner.MigrateContext(ctx)
+	if !args.quiet || err != nil {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TENANT\tRESULT\tAPPLIED\tDURATION\tERROR")
+
+		for _, result := range results {
+			outcome, errMessage := "ok", "-"
+			if result.Err != nil {
+				outcome, errMessage = "failed", result.Err.Error()
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", result.Target, outcome, len(result.Applied), result.Duration.Round(time.Millisecond), errMessage)
 		}
 
-		appliedMigrations[migration.Version] = true
+		w.Flush()
 	}
 
-	if len(appliedMigrations) == 0 {
-		printf(args, "No migrations to apply\n")
+	return err
+}
+
+func printTenantPlan(ctx context.Context, setup gloat.TenantSetup, target string) error {
+	gl, release, err := setup(ctx, target)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	plan, err := gl.PlanUpContext(ctx)
+	if err != nil {
+		return err
 	}
 
+	printPlan(plan)
+
 	return nil
 }
 
-func latestCmd(args arguments) error {
+// readTargets reads the -tenants file, one target per line, skipping blank
+// lines and # comments.
+func readTargets(path string) ([]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		targets = append(targets, line)
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%s lists no tenants", path)
+	}
+
+	return targets, nil
+}
+
+// tenantSetup builds the Gloat of a -tenants target with a connection of its
+// own, closed once the target is migrated.
+func tenantSetup(args arguments) gloat.TenantSetup {
+	return func(_ context.Context, target string) (*gloat.Gloat, func(), error) {
+		rawURL, options, err := tenantURL(args, target)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		gl, db, err := openGloat(args, rawURL, options)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return gl, func() { db.Close() }, nil
+	}
+}
+
+// tenantURL returns the database URL and the table options of a -tenants
+// target. A target with a scheme is a database URL of its own. Any other
+// target is a schema of the -url PostgreSQL database, which becomes the
+// search_path of the connection and holds the migrations table.
+func tenantURL(args arguments, target string) (string, []gloat.TableOption, error) {
+	options := []gloat.TableOption{gloat.WithTable(args.table)}
+
+	if strings.Contains(target, "://") {
+		if args.schema != "" {
+			options = append(options, gloat.WithSchema(args.schema))
+		}
+
+		return target, options, nil
+	}
+
+	driver, _, err := parseDatabaseURL(args.url)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if driver != "postgres" {
+		return "", nil, fmt.Errorf("tenant %s is a schema, which needs a postgres url", target)
+	}
+
+	u, err := url.Parse(args.url)
+	if err != nil {
+		return "", nil, err
+	}
+
+	query := u.Query()
+	query.Set("search_path", target)
+	u.RawQuery = query.Encode()
+
+	return u.String(), append(options, gloat.WithSchema(target)), nil
+}
+
+func redoCmd(ctx context.Context, args arguments) (err error) {
+	if err := checkArgs(args, 0); err != nil {
+		return err
+	}
+
+	gl, err := setupGloat(args)
+	if err != nil {
+		return err
+	}
+
+	if !args.dryRun {
+		if err := gl.LockContext(ctx); err != nil {
+			return err
+		}
+		defer unlock(gl, &err)
+	}
+
+	plan, err := gl.PlanRedoContext(ctx, args.n)
+	if err != nil {
+		return err
+	}
+
+	if args.dryRun {
+		printPlan(plan)
+		return nil
+	}
+
+	if len(plan) == 0 {
+		printf(args, "No migrations to redo\n")
+		return nil
+	}
+
+	return executePlan(ctx, gl, args, plan)
+}
+
+func latestCmd(ctx context.Context, args arguments) error {
+	if err := checkArgs(args, 0); err != nil {
+		return err
+	}
+
 	gl, err := setupGloat(args)
 	if err != nil {
 		return err
 	}
 
-	latest, err := gl.Latest()
+	latest, err := gl.LatestContext(ctx)
 	if err != nil {
 		return err
 	}
@@ -129,13 +460,17 @@ func latestCmd(args arguments) error {
 	return nil
 }
 
-func presentCmd(args arguments) error {
+func presentCmd(ctx context.Context, args arguments) error {
+	if err := checkArgs(args, 0); err != nil {
+		return err
+	}
+
 	gl, err := setupGloat(args)
 	if err != nil {
 		return err
 	}
 
-	migrations, err := gl.Present()
+	migrations, err := gl.PresentContext(ctx)
 	if err != nil {
 		return err
 	}
@@ -152,13 +487,17 @@ func presentCmd(args arguments) error {
 	return nil
 }
 
-func currentCmd(args arguments) error {
+func currentCmd(ctx context.Context, args arguments) error {
+	if err := checkArgs(args, 0); err != nil {
+		return err
+	}
+
 	gl, err := setupGloat(args)
 	if err != nil {
 		return err
 	}
 
-	current, err := gl.Current()
+	current, err := gl.CurrentContext(ctx)
 	if err != nil {
 		return err
 	}
@@ -169,154 +508,978 @@ func currentCmd(args arguments) error {
 	return nil
 }
 
-func migrateToCmd(args arguments) error {
+func statusCmd(ctx context.Context, args arguments) error {
+	if err := checkArgs(args, 0); err != nil {
+		return err
+	}
+
 	gl, err := setupGloat(args)
 	if err != nil {
 		return err
 	}
-	if len(args.rest) < 2 {
-		return errors.New("migrate to requires a version to migrate to")
-	}
 
-	version, err := strconv.ParseInt(args.rest[1], 10, 64)
+	report, err := gl.StatusContext(ctx)
 	if err != nil {
 		return err
 	}
 
-	migrations, err := gl.AppliedAfter(version)
+	var outOfOrder gloat.Migrations
+	for _, status := range report {
+		if status.OutOfOrder {
+			outOfOrder = append(outOfOrder, &gloat.Migration{Version: status.Version})
+		}
+	}
+	warnOutOfOrder(gl, outOfOrder)
+
+	switch args.format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tSTATE\tAPPLIED AT\tREVERSIBLE\tTRANSACTION\tPATH")
+
+		for _, status := range report {
+			appliedAt, reversible, transaction := "-", "-", "-"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			if status.Options != nil {
+				reversible = strconv.FormatBool(status.Reversible)
+				transaction = strconv.FormatBool(status.Options.Transaction)
+			}
+
+			state := string(status.State)
+			if status.OutOfOrder {
+				state += " (out of order)"
+			}
+
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", status.Version, state, appliedAt, reversible, transaction, status.Path)
+		}
+
+		return w.Flush()
+	}
+
+	return fmt.Errorf("unsupported status format %s", args.format)
+}
+
+func historyCmd(ctx context.Context, args arguments) error {
+	if err := checkArgs(args, 1); err != nil {
+		return err
+	}
+
+	gl, err := setupGloat(args)
 	if err != nil {
 		return err
 	}
 
-	for _, migration := range migrations {
-		printf(args, "Reverting: %d...\n", migration.Version)
+	var filter gloat.HistoryFilter
 
-		if err := gl.Revert(migration); err != nil {
+	if len(args.rest) > 1 {
+		if filter.Version, err = strconv.ParseInt(args.rest[1], 10, 64); err != nil {
 			return err
 		}
 	}
+	if filter.Since, err = parseTime(args.since); err != nil {
+		return err
+	}
+	if filter.Until, err = parseTime(args.until); err != nil {
+		return err
+	}
 
-	return nil
+	entries, err := gl.HistoryContext(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	switch args.format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "STARTED AT\tVERSION\tACTION\tRESULT\tDURATION\tUSER\tHOST\tTAG\tERROR")
+
+		for _, entry := range entries {
+			result := "ok"
+			if !entry.Success {
+				result = "failed"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				entry.StartedAt.Format(time.RFC3339), migrationName(entry.Version, entry.Name), entry.Action, result, entry.Duration,
+				entry.AppliedBy, entry.Host, entry.Tag, strings.Replace(entry.Error, "\n", " ", -1))
+		}
+
+		return w.Flush()
+	}
+
+	return fmt.Errorf("unsupported history format %s", args.format)
+}
+
+// parseTime parses a -since or -until time, given either as a date or in
+// RFC 3339. A blank time is the zero time.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
 }
 
-func downCmd(args arguments) error {
+func verifyCmd(ctx context.Context, args arguments) error {
+	if err := checkArgs(args, 0); err != nil {
+		return err
+	}
+
 	gl, err := setupGloat(args)
 	if err != nil {
 		return err
 	}
 
-	migration, err := gl.Current()
+	migrations, err := gl.VerifyContext(ctx)
 	if err != nil {
 		return err
 	}
 
-	if migration == nil {
-		printf(args, "No migrations to revert\n")
-		return nil
+	for _, migration := range migrations {
+		printf(args, "Changed: %d %s\n", migration.Version, migration.Path)
 	}
 
-	printf(args, "Reverting: %d...\n", migration.Version)
-
-	if err := gl.Revert(migration); err != nil {
-		return err
+	if len(migrations) != 0 {
+		return fmt.Errorf("%d applied migrations changed since they were applied", len(migrations))
 	}
 
+	printf(args, "No changed migrations\n")
+
 	return nil
 }
 
-func newCmd(args arguments) error {
-	if _, err := os.Stat(args.src); os.IsNotExist(err) {
+func forceCmd(ctx context.Context, args arguments) (err error) {
+	if err := checkArgs(args, 1); err != nil {
 		return err
 	}
 
+	gl, err := setupGloat(args)
+	if err != nil {
+		return err
+	}
 	if len(args.rest) < 2 {
-		return errors.New("new requires a migration name given as an argument")
+		return errors.New("force requires a version to mark as applied")
 	}
 
-	migration := gloat.GenerateMigration(strings.Join(args.rest[1:], "_"))
-	migrationDirectoryPath := filepath.Join(args.src, migration.Path)
-
-	if err := os.MkdirAll(migrationDirectoryPath, 0755); err != nil {
+	version, err := strconv.ParseInt(args.rest[1], 10, 64)
+	if err != nil {
 		return err
 	}
 
-	f, err := os.Create(filepath.Join(migrationDirectoryPath, "up.sql"))
-	if err != nil {
+	if err := gl.LockContext(ctx); err != nil {
 		return err
 	}
-	f.Close()
+	defer unlock(gl, &err)
 
-	f, err = os.Create(filepath.Join(migrationDirectoryPath, "down.sql"))
-	if err != nil {
+	if err := gl.ForceContext(ctx, version); err != nil {
 		return err
 	}
-	f.Close()
 
-	printf(args, "Created %s\n", migrationDirectoryPath)
+	printf(args, "Forced: %d\n", version)
 
 	return nil
 }
 
-func parseArguments() arguments {
-	var args arguments
-
-	urlDefault := os.Getenv("DATABASE_URL")
-	urlUsage := `database connection url`
+func cleanDirtyCmd(ctx context.Context, args arguments) (err error) {
+	if err := checkArgs(args, 0); err != nil {
+		return err
+	}
 
-	srcDefault := os.Getenv("DATABASE_SRC")
-	if srcDefault == "" {
-		srcDefault = "database/migrations"
+	gl, err := setupGloat(args)
+	if err != nil {
+		return err
 	}
-	srcUsage := `the folder with migrations`
 
-	flag.StringVar(&args.url, "url", urlDefault, urlUsage)
-	flag.StringVar(&args.src, "src", srcDefault, srcUsage)
-	flag.BoolVar(&args.quiet, "quiet", false, "Output only errors")
+	if err := gl.LockContext(ctx); err != nil {
+		return err
+	}
+	defer unlock(gl, &err)
 
-	flag.Usage = func() { fmt.Fprintf(os.Stderr, usage) }
+	migrations, err := gl.CleanDirtyContext(ctx)
+	if err != nil {
+		return err
+	}
 
-	flag.Parse()
+	for _, migration := range migrations {
+		printf(args, "Cleaned: %d\n", migration.Version)
+	}
 
-	args.rest = flag.Args()
+	if len(migrations) == 0 {
+		printf(args, "No dirty migrations\n")
+	}
 
-	return args
+	return nil
 }
 
-func setupGloat(args arguments) (*gloat.Gloat, error) {
-	u, err := url.Parse(args.url)
-	if err != nil {
-		return nil, err
+func baselineCmd(ctx context.Context, args arguments) (err error) {
+	if err := checkArgs(args, 1); err != nil {
+		return err
 	}
 
-	db, err := sql.Open(u.Scheme, args.url)
+	gl, err := setupGloat(args)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if len(args.rest) < 2 {
+		return errors.New("baseline requires a version to mark as applied up to")
 	}
 
-	store, err := databaseStoreFactory(u.Scheme, db)
+	version, err := strconv.ParseInt(args.rest[1], 10, 64)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &gloat.Gloat{
-		Store:    store,
-		Source:   gloat.NewFileSystemSource(args.src),
-		Executor: gloat.NewSQLExecutor(db),
-	}, nil
-}
+	if err := gl.LockContext(ctx); err != nil {
+		return err
+	}
+	defer unlock(gl, &err)
 
-func databaseStoreFactory(driver string, db *sql.DB) (gloat.Store, error) {
-	switch driver {
-	case "postgres", "postgresql":
-		return gloat.NewPostgreSQLStore(db), nil
-	case "mysql":
-		return gloat.NewMySQLStore(db), nil
-	case "sqlite", "sqlite3":
-		return gloat.NewMySQLStore(db), nil
+	baseline := gl.BaselineContext
+	if args.force {
+		baseline = gl.ForceBaselineContext
 	}
 
-	return nil, errors.New("unsupported database driver " + driver)
+	migrations, err := baseline(ctx, version)
+	for _, migration := range migrations {
+		printf(args, "Baselined: %d\n", migration.Version)
+	}
+	if errors.Is(err, gloat.ErrNotEmpty) {
+		return errors.New("migrations are already applied, pass -force to baseline anyway")
+	}
+
+	return err
 }
+
+func squashCmd(ctx context.Context, args arguments) error {
+	if err := checkArgs(args, 1); err != nil {
+		return err
+	}
+
+	if len(args.rest) < 2 {
+		return errors.New("squash requires the last version to squash")
+	}
+
+	version, err := strconv.ParseInt(args.rest[1], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	migrations, err := gloat.CollectContext(ctx, gloat.NewFileSystemSource(args.src))
+	if err != nil {
+		return err
+	}
+
+	squashed, err := gloat.Squash(migrations, version)
+	if err != nil {
+		return err
+	}
+
+	optionsJSON, err := json.MarshalIndent(squashed.Options, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write the squashed migration before removing the ones it replaces, so
+	// nothing is lost if it fails.
+	squashedPath := filepath.Join(args.src, squashed.Path)
+	if err := os.MkdirAll(squashedPath, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(squashedPath, "up.sql"), squashed.UpSQL, 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(squashedPath, "options.json"), append(optionsJSON, '\n'), 0644); err != nil {
+		return err
+	}
+
+	var removed int
+	for _, migration := range migrations {
+		if migration.Version > version || filepath.Clean(migration.Path) == filepath.Clean(squashedPath) {
+			continue
+		}
+
+		if err := os.RemoveAll(migration.Path); err != nil {
+			return err
+		}
+		removed++
+	}
+
+	printf(args, "Squashed %d migrations into %s\n", removed, squashedPath)
+
+	return nil
+}
+
+func markCmd(ctx context.Context, args arguments) (err error) {
+	if err := checkArgs(args, 1); err != nil {
+		return err
+	}
+
+	gl, err := setupGloat(args)
+	if err != nil {
+		return err
+	}
+	if len(args.rest) < 2 {
+		return errors.New("mark requires a version to mark as applied")
+	}
+
+	version, err := strconv.ParseInt(args.rest[1], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	if err := gl.LockContext(ctx); err != nil {
+		return err
+	}
+	defer unlock(gl, &err)
+
+	if err := gl.MarkAppliedContext(ctx, version); err != nil {
+		return err
+	}
+
+	printf(args, "Marked: %d\n", version)
+
+	return nil
+}
+
+func unmarkCmd(ctx context.Context, args arguments) (err error) {
+	if err := checkArgs(args, 1); err != nil {
+		return err
+	}
+
+	gl, err := setupGloat(args)
+	if err != nil {
+		return err
+	}
+	if len(args.rest) < 2 {
+		return errors.New("unmark requires a version to mark as unapplied")
+	}
+
+	version, err := strconv.ParseInt(args.rest[1], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	if err := gl.LockContext(ctx); err != nil {
+		return err
+	}
+	defer unlock(gl, &err)
+
+	if err := gl.MarkUnappliedContext(ctx, version); err != nil {
+		return err
+	}
+
+	printf(args, "Unmarked: %d\n", version)
+
+	return nil
+}
+
+func migrateToCmd(ctx context.Context, args arguments) (err error) {
+	if err := checkArgs(args, 1); err != nil {
+		return err
+	}
+
+	gl, err := setupGloat(args)
+	if err != nil {
+		return err
+	}
+	if len(args.rest) < 2 {
+		return errors.New("migrate to requires a version to migrate to")
+	}
+	if args.upOnly && args.downOnly {
+		return errors.New("-up-only and -down-only cannot be used together")
+	}
+
+	version, err := strconv.ParseInt(args.rest[1], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	if !args.dryRun {
+		if err := gl.LockContext(ctx); err != nil {
+			return err
+		}
+		defer unlock(gl, &err)
+	}
+
+	plan, err := gl.PlanToContext(ctx, version)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case args.upOnly:
+		err = plan.Only(gloat.Up)
+	case args.downOnly:
+		err = plan.Only(gloat.Down)
+	}
+	if err != nil {
+		return err
+	}
+
+	if args.dryRun {
+		printPlan(plan)
+		return nil
+	}
+
+	if len(plan) == 0 {
+		printf(args, "Already at %d\n", version)
+		return nil
+	}
+
+	return executePlan(ctx, gl, args, plan)
+}
+
+func downCmd(ctx context.Context, args arguments) (err error) {
+	if err := checkArgs(args, 0); err != nil {
+		return err
+	}
+
+	gl, err := setupGloat(args)
+	if err != nil {
+		return err
+	}
+
+	if !args.dryRun {
+		if err := gl.LockContext(ctx); err != nil {
+			return err
+		}
+		defer unlock(gl, &err)
+	}
+
+	plan, err := gl.PlanDownContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if args.dryRun {
+		printPlan(plan)
+		return nil
+	}
+
+	if len(plan) == 0 {
+		printf(args, "No migrations to revert\n")
+		return nil
+	}
+
+	return executePlan(ctx, gl, args, plan)
+}
+
+// executePlan runs the steps of a plan one by one, printing each of them.
+func executePlan(ctx context.Context, gl *gloat.Gloat, args arguments, plan gloat.Plan) error {
+	warnOutOfOrder(gl, plan.OutOfOrder())
+
+	for _, step := range plan {
+		if step.Direction == gloat.Down {
+			printf(args, "Reverting: %s...\n", migrationName(step.Migration.Version, step.Migration.Name))
+		} else {
+			printf(args, "Applying: %s...\n", migrationName(step.Migration.Version, step.Migration.Name))
+		}
+
+		if err := gl.ExecuteContext(ctx, gloat.Plan{step}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrationName returns the version of a migration or the name of a
+// repeatable one, which has no version.
+func migrationName(version int64, name string) string {
+	if name != "" {
+		return name
+	}
+
+	return strconv.FormatInt(version, 10)
+}
+
+// warnOutOfOrder prints the out of order migrations to stderr, unless the
+// policy allows them.
+func warnOutOfOrder(gl *gloat.Gloat, migrations gloat.Migrations) {
+	if gl.OutOfOrder == gloat.OutOfOrderAllow || len(migrations) == 0 {
+		return
+	}
+
+	versions := make([]string, 0, len(migrations))
+	for _, migration := range migrations {
+		versions = append(versions, strconv.FormatInt(migration.Version, 10))
+	}
+
+	fmt.Fprintf(os.Stderr, "Warning: migrations %s are older than the latest applied migration\n", strings.Join(versions, ", "))
+}
+
+// printPlan prints the statements of a plan as an SQL script. It is printed
+// even with -quiet, as it is the output the user asked for.
+func printPlan(plan gloat.Plan) {
+	if len(plan) == 0 {
+		fmt.Println("-- Nothing to do")
+		return
+	}
+
+	for _, step := range plan {
+		fmt.Printf("-- %s %s (%s)\n", step.Direction, migrationName(step.Migration.Version, step.Migration.Name), step.Migration.Path)
+		if step.OutOfOrder {
+			fmt.Println("-- Out of order, older than the latest applied migration")
+		}
+
+		sql := strings.TrimSpace(step.SQL)
+		switch {
+		case sql != "":
+			fmt.Println(sql)
+		case step.Direction == gloat.Up && step.Migration.UpFunc != nil,
+			step.Direction == gloat.Down && step.Migration.DownFunc != nil:
+			fmt.Println("-- Go migration, the statements it runs are not known")
+		}
+
+		for _, statement := range step.StoreStatements {
+			fmt.Printf("%s; -- %s\n", statement.Query, formatArgs(statement.Args))
+		}
+
+		fmt.Println()
+	}
+}
+
+func formatArgs(args []interface{}) string {
+	formatted := make([]string, 0, len(args))
+	for _, arg := range args {
+		switch arg := arg.(type) {
+		case time.Time:
+			formatted = append(formatted, arg.Format(time.RFC3339))
+		case string:
+			formatted = append(formatted, strconv.Quote(arg))
+		default:
+			formatted = append(formatted, fmt.Sprint(arg))
+		}
+	}
+
+	return "args: " + strings.Join(formatted, ", ")
+}
+
+func newCmd(args arguments) error {
+	if _, err := os.Stat(args.src); os.IsNotExist(err) {
+		return err
+	}
+
+	// -format is the status and history output format, the layout of the
+	// migration is given with -layout.
+	if args.format != "table" {
+		return errors.New("new takes the migration layout as -layout, not -format")
+	}
+
+	if len(args.rest) < 2 {
+		return errors.New("new requires a migration name given as an argument")
+	}
+
+	migration := gloat.GenerateMigration(strings.Join(args.rest[1:], "_"))
+
+	switch args.layout {
+	case "directory":
+	case "single":
+		return newFileCmd(args, migration)
+	default:
+		return fmt.Errorf("unsupported migration layout %s", args.layout)
+	}
+
+	migrationDirectoryPath := filepath.Join(args.src, migration.Path)
+
+	if err := os.MkdirAll(migrationDirectoryPath, 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(migrationDirectoryPath, "up.sql"))
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	f, err = os.Create(filepath.Join(migrationDirectoryPath, "down.sql"))
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	printf(args, "Created %s\n", migrationDirectoryPath)
+
+	return nil
+}
+
+func newFileCmd(args arguments, migration *gloat.Migration) error {
+	migrationFilePath := filepath.Join(args.src, migration.Path+".sql")
+
+	content := "-- +gloat Up\n\n\n-- +gloat Down\n"
+	if err := ioutil.WriteFile(migrationFilePath, []byte(content), 0644); err != nil {
+		return err
+	}
+
+	printf(args, "Created %s\n", migrationFilePath)
+
+	return nil
+}
+
+func parseArguments() arguments {
+	args := arguments{
+		url:         os.Getenv("DATABASE_URL"),
+		src:         os.Getenv("DATABASE_SRC"),
+		table:       "schema_migrations",
+		format:      "table",
+		outOfOrder:  "warn",
+		lockTimeout: gloat.DefaultLockTimeout,
+	}
+
+	if args.src == "" {
+		args.src = "database/migrations"
+	}
+
+	defineFlags(flag.CommandLine, &args)
+
+	flag.Usage = func() { fmt.Fprintf(os.Stderr, usage) }
+
+	flag.Parse()
+
+	args.rest = flag.Args()
+
+	// The flags can follow the command name too, e.g. gloat down -dry-run.
+	if len(args.rest) > 0 {
+		flags := commandFlags(args.rest[0], &args)
+		args.rest = append(args.rest[:1], parseCommand(flags, args.rest[1:])...)
+	}
+
+	return args
+}
+
+// defineFlags defines the global flags, defaulting to the values already in
+// the arguments.
+func defineFlags(flags *flag.FlagSet, args *arguments) {
+	flags.StringVar(&args.url, "url", args.url, `database connection url`)
+	flags.StringVar(&args.src, "src", args.src, `the folder with migrations`)
+	flags.BoolVar(&args.quiet, "quiet", args.quiet, "Output only errors")
+	flags.StringVar(&args.table, "table", args.table, "The table applied migrations are recorded in")
+	flags.StringVar(&args.schema, "schema", args.schema, "The schema of the migrations table")
+	flags.StringVar(&args.tag, "tag", args.tag, "A deploy identifier recorded with the applied migrations")
+	flags.Var(&args.vars, "var", "A key=value variable the migrations are rendered with as templates")
+	flags.StringVar(&args.varFile, "var-file", args.varFile, "A file of key=value variables, one per line")
+	flags.StringVar(&args.since, "since", args.since, "List the history from the given time on")
+	flags.StringVar(&args.until, "until", args.until, "List the history before the given time")
+	flags.StringVar(&args.format, "format", args.format, "The status and history output format, table or json")
+	flags.BoolVar(&args.force, "force", args.force, "Baseline even if migrations are already applied")
+	flags.StringVar(&args.outOfOrder, "out-of-order", args.outOfOrder, "What to do with unapplied migrations older than the latest applied one: allow, warn or error")
+	flags.BoolVar(&args.upOnly, "up-only", args.upOnly, "Fail if to would revert migrations")
+	flags.BoolVar(&args.downOnly, "down-only", args.downOnly, "Fail if to would apply migrations")
+	flags.BoolVar(&args.dryRun, "dry-run", args.dryRun, "Print the statements of up, down, to and redo instead of running them")
+	flags.DurationVar(&args.timeout, "timeout", args.timeout, "Abort the command after the given duration")
+	flags.DurationVar(&args.lockTimeout, "lock-timeout", args.lockTimeout, "How long to wait for the migration lock")
+}
+
+// commandFlags returns the flags accepted after the name of a command, the
+// global ones included.
+func commandFlags(name string, args *arguments) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = flag.Usage
+
+	defineFlags(flags, args)
+
+	switch name {
+	case "up":
+		flags.StringVar(&args.tenants, "tenants", "", "A file of schemas or database URLs to migrate, one per line")
+		flags.IntVar(&args.parallel, "parallel", 1, "How many tenants to migrate at once")
+	case "redo":
+		flags.IntVar(&args.n, "n", 1, "The number of migrations to redo")
+	case "new":
+		flags.StringVar(&args.layout, "layout", "directory", "The migration layout, directory or single")
+	}
+
+	return flags
+}
+
+// parseCommand parses the flags given after a command name, wherever they
+// are among its arguments, and returns the arguments.
+func parseCommand(flags *flag.FlagSet, arguments []string) []string {
+	var rest []string
+
+	for {
+		flags.Parse(arguments)
+
+		arguments = flags.Args()
+		if len(arguments) == 0 {
+			return rest
+		}
+
+		rest = append(rest, arguments[0])
+		arguments = arguments[1:]
+	}
+}
+
+// checkArgs fails if a command is given more than max arguments after its
+// name, instead of silently ignoring them.
+func checkArgs(args arguments, max int) error {
+	if extra := args.rest[1:]; len(extra) > max {
+		return fmt.Errorf("unexpected arguments to %s: %s", args.rest[0], strings.Join(extra[max:], " "))
+	}
+
+	return nil
+}
+
+func setupGloat(args arguments) (*gloat.Gloat, error) {
+	options := []gloat.TableOption{gloat.WithTable(args.table)}
+	if args.schema != "" {
+		options = append(options, gloat.WithSchema(args.schema))
+	}
+
+	gl, _, err := openGloat(args, args.url, options)
+	return gl, err
+}
+
+// openGloat opens a connection to a database URL and builds a Gloat on top of
+// it. The connection is closed if the Gloat cannot be built.
+func openGloat(args arguments, rawURL string, options []gloat.TableOption) (*gloat.Gloat, *sql.DB, error) {
+	driver, dsn, err := parseDatabaseURL(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Every connection to an in-memory SQLite3 database opens a new, empty
+	// one and file ones lock each other out, so keep a single connection.
+	if driver == "sqlite" || driver == "sqlite3" {
+		db.SetMaxOpenConns(1)
+	}
+
+	gl, err := newGloat(args, driver, db, options)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	return gl, db, nil
+}
+
+func newGloat(args arguments, driver string, db *sql.DB, options []gloat.TableOption) (*gloat.Gloat, error) {
+	outOfOrder, err := gloat.ParseOutOfOrderPolicy(args.outOfOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := databaseStoreFactory(driver, db, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	locker, err := lockerFactory(driver, db, args.lockTimeout, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	executor, err := executorFactory(driver, db)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := historyStoreFactory(driver, db, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	gl := &gloat.Gloat{
+		Store:        store,
+		Source:       gloat.NewFileSystemSource(args.src),
+		Executor:     executor,
+		Locker:       locker,
+		HistoryStore: history,
+		OutOfOrder:   outOfOrder,
+		Tag:          args.tag,
+	}
+
+	vars, err := loadVars(args.varFile, args.vars)
+	if err != nil {
+		return nil, err
+	}
+
+	// The migrations are rendered as templates only when variables are given,
+	// so plain SQL with {{ in it keeps working.
+	if vars != nil {
+		gl.Vars = vars
+	}
+
+	return gl, nil
+}
+
+// varsFlag collects the repeated -var flags.
+type varsFlag []string
+
+func (f *varsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *varsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// loadVars reads the key=value variables of a file, skipping blank lines and
+// # comments, and overrides them with the ones given as flags. It returns nil
+// if there are no variables at all.
+func loadVars(path string, flags []string) (gloat.Vars, error) {
+	var vars gloat.Vars
+
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		vars = gloat.Vars{}
+		for i, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			key, value, err := parseVar(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+			}
+			vars[key] = value
+		}
+	}
+
+	for _, variable := range flags {
+		key, value, err := parseVar(variable)
+		if err != nil {
+			return nil, err
+		}
+
+		if vars == nil {
+			vars = gloat.Vars{}
+		}
+		vars[key] = value
+	}
+
+	return vars, nil
+}
+
+func parseVar(variable string) (key string, value string, err error) {
+	parts := strings.SplitN(variable, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return "", "", fmt.Errorf("variable %q is not in the key=value form", variable)
+	}
+
+	return strings.TrimSpace(parts[0]), parts[1], nil
+}
+
+// parseDatabaseURL splits a database URL into the driver name and the data
+// source name to open it with. PostgreSQL takes the whole URL, while the other
+// drivers take what follows the scheme, e.g. sqlite3:///var/db/app.db,
+// sqlite3://:memory: and sqlite3://app.db?_foreign_keys=1 open /var/db/app.db,
+// :memory: and app.db?_foreign_keys=1.
+func parseDatabaseURL(rawURL string) (driver string, dsn string, err error) {
+	parts := strings.SplitN(rawURL, "://", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", errors.New("database url has no scheme, e.g. postgres:// or sqlite3://")
+	}
+
+	driver, dsn = parts[0], parts[1]
+	switch driver {
+	case "postgres", "postgresql":
+		// The PostgreSQL driver takes the whole URL and is registered as
+		// postgres.
+		return "postgres", rawURL, nil
+	case "sqlite":
+		// The sqlite driver is registered as sqlite3.
+		driver = "sqlite3"
+	}
+
+	if dsn == "" {
+		return "", "", fmt.Errorf("cannot find the database in %s", rawURL)
+	}
+
+	return driver, dsn, nil
+}
+
+func databaseStoreFactory(driver string, db *sql.DB, options ...gloat.TableOption) (gloat.Store, error) {
+	switch driver {
+	case "postgres", "postgresql":
+		return gloat.NewPostgreSQLStore(db, options...), nil
+	case "mysql":
+		return gloat.NewMySQLStore(db, options...), nil
+	case "sqlite", "sqlite3":
+		return gloat.NewSQLite3Store(db, options...), nil
+	}
+
+	return nil, errors.New("unsupported database driver " + driver)
+}
+
+func historyStoreFactory(driver string, db *sql.DB, options ...gloat.TableOption) (gloat.HistoryStore, error) {
+	switch driver {
+	case "postgres", "postgresql":
+		return gloat.NewPostgreSQLHistoryStore(db, options...), nil
+	case "mysql":
+		return gloat.NewMySQLHistoryStore(db, options...), nil
+	case "sqlite", "sqlite3":
+		return gloat.NewSQLite3HistoryStore(db, options...), nil
+	}
+
+	return nil, errors.New("unsupported database driver " + driver)
+}
+
+func executorFactory(driver string, db *sql.DB) (gloat.Executor, error) {
+	switch driver {
+	case "postgres", "postgresql":
+		return gloat.NewPostgreSQLExecutor(db), nil
+	case "mysql":
+		return gloat.NewMySQLExecutor(db), nil
+	case "sqlite", "sqlite3":
+		return gloat.NewSQLite3Executor(db), nil
+	}
+
+	return nil, errors.New("unsupported database driver " + driver)
+}
+
+func lockerFactory(driver string, db *sql.DB, timeout time.Duration, options ...gloat.TableOption) (gloat.Locker, error) {
+	switch driver {
+	case "postgres", "postgresql":
+		return gloat.NewPostgreSQLLocker(db, timeout, options...), nil
+	case "mysql":
+		return gloat.NewMySQLLocker(db, timeout, options...), nil
+	case "sqlite", "sqlite3":
+		return gloat.NewSQLite3Locker(db, timeout, options...), nil
+	}
+
+	return nil, errors.New("unsupported database driver " + driver)
+}
+
+// unlock releases the migration lock. The unlock error is returned through
+// err, if the command itself succeeded, and printed otherwise.
+func unlock(gl *gloat.Gloat, err *error) {
+	unlockErr := gl.Unlock()
+	if unlockErr == nil {
+		return
+	}
+
+	if *err == nil {
+		*err = unlockErr
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Error: cannot release migration lock: %+v\n", unlockErr)
+}
+
 func printf(args arguments, str string, subs ...interface{}) {
 	if args.quiet != true {
 		fmt.Printf(str, subs...)
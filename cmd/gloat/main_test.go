@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webedx-spark/gloat"
+)
+
+func TestParseDatabaseURL(t *testing.T) {
+	cases := []struct {
+		url    string
+		driver string
+		dsn    string
+	}{
+		{"postgres://postgres@localhost/gloat_test?sslmode=disable", "postgres", "postgres://postgres@localhost/gloat_test?sslmode=disable"},
+		{"postgresql://localhost/gloat_test", "postgres", "postgresql://localhost/gloat_test"},
+		{"mysql://travis@tcp(127.0.0.1:3306)/gloat_test", "mysql", "travis@tcp(127.0.0.1:3306)/gloat_test"},
+		{"sqlite3://:memory:", "sqlite3", ":memory:"},
+		{"sqlite3:///var/db/app.db", "sqlite3", "/var/db/app.db"},
+		{"sqlite3://app.db?_foreign_keys=1", "sqlite3", "app.db?_foreign_keys=1"},
+		{"sqlite://file:app.db?cache=shared", "sqlite3", "file:app.db?cache=shared"},
+	}
+
+	for _, c := range cases {
+		driver, dsn, err := parseDatabaseURL(c.url)
+		assert.Nil(t, err, c.url)
+		assert.Equal(t, c.driver, driver, c.url)
+		assert.Equal(t, c.dsn, dsn, c.url)
+	}
+}
+
+func TestParseDatabaseURL_Invalid(t *testing.T) {
+	for _, url := range []string{"", "app.db", "sqlite3://"} {
+		_, _, err := parseDatabaseURL(url)
+		assert.NotNil(t, err, url)
+	}
+}
+
+func TestLoadVars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gloat")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tenant.vars")
+	content := "# Tenant one\nschema = tenant_one\n\ntablespace=fast\n"
+	require.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+
+	vars, err := loadVars(path, []string{"schema=tenant_two", "role=app=rw"})
+	require.Nil(t, err)
+
+	assert.Equal(t, gloat.Vars{"schema": "tenant_two", "tablespace": "fast", "role": "app=rw"}, vars)
+}
+
+func TestLoadVars_None(t *testing.T) {
+	vars, err := loadVars("", nil)
+	assert.Nil(t, err)
+	assert.Nil(t, vars)
+}
+
+func TestLoadVars_Invalid(t *testing.T) {
+	_, err := loadVars("", []string{"schema"})
+	assert.NotNil(t, err)
+}
+
+func TestTenantURL(t *testing.T) {
+	args := arguments{url: "postgres://postgres@localhost/gloat_test?sslmode=disable", table: "schema_migrations"}
+
+	rawURL, options, err := tenantURL(args, "tenant_one")
+	require.Nil(t, err)
+	assert.Equal(t, "postgres://postgres@localhost/gloat_test?search_path=tenant_one&sslmode=disable", rawURL)
+	assert.Len(t, options, 2)
+
+	rawURL, options, err = tenantURL(args, "sqlite3:///var/db/tenant_two.db")
+	require.Nil(t, err)
+	assert.Equal(t, "sqlite3:///var/db/tenant_two.db", rawURL)
+	assert.Len(t, options, 1)
+
+	args.url = "sqlite3:///var/db/app.db"
+	_, _, err = tenantURL(args, "tenant_one")
+	assert.NotNil(t, err)
+}
+
+func TestReadTargets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gloat")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tenants.txt")
+	content := "# Customers\ntenant_one\n\n  tenant_two  \n"
+	require.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+
+	targets, err := readTargets(path)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"tenant_one", "tenant_two"}, targets)
+
+	require.Nil(t, ioutil.WriteFile(path, []byte("# Customers\n"), 0644))
+
+	_, err = readTargets(path)
+	assert.NotNil(t, err)
+}
@@ -1,7 +1,11 @@
 package gloat
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 )
 
 // IrreversibleError is the error return when we're trying to reverse a
@@ -15,62 +19,276 @@ func (err IrreversibleError) Error() string {
 	return fmt.Sprintf("cannot reverse migration %d", err.Version)
 }
 
+// DirtyError is the error returned when we're trying to run migrations while
+// a migration, run outside of a transaction, failed halfway. The database has
+// to be inspected and the migration resolved by hand.
+type DirtyError struct {
+	Version int64
+}
+
+// Error implements the error interface.
+func (err DirtyError) Error() string {
+	return fmt.Sprintf("migration %d is dirty, inspect the database and force or clean it", err.Version)
+}
+
+// MigrationError is the error returned when an SQLExecutor fails to run a
+// migration. Statement is the failing statement, if the executor splits
+// migrations into statements. RolledBack tells whether the changes of the
+// migration were rolled back with its transaction.
+type MigrationError struct {
+	Version    int64
+	Path       string
+	Direction  Direction
+	Statement  *Statement
+	RolledBack bool
+	Err        error
+}
+
+// Error implements the error interface.
+func (err MigrationError) Error() string {
+	state := "not rolled back"
+	if err.RolledBack {
+		state = "rolled back"
+	}
+
+	return fmt.Sprintf("migration %d failed going %s, %s: %v", err.Version, err.Direction, state, err.Err)
+}
+
+// Unwrap returns the cause of the failure.
+func (err MigrationError) Unwrap() error {
+	return err.Err
+}
+
 // Executor is a type that executes migrations up and down.
 type Executor interface {
 	Up(*Migration, Store) error
 	Down(*Migration, Store) error
 }
 
-// SQLExecutor is a type that executes migrations in a database.
+// ContextExecutor is an Executor that can be cancelled through a context.
+type ContextExecutor interface {
+	Executor
+
+	UpContext(context.Context, *Migration, Store) error
+	DownContext(context.Context, *Migration, Store) error
+}
+
+// SQLExecutor is a type that executes migrations in a database. Executors
+// created for a Dialect execute the statements of a migration one by one,
+// otherwise the whole migration is sent in a single statement.
 type SQLExecutor struct {
-	db SQLTransactor
+	db      SQLTransactor
+	dialect Dialect
 }
 
 // Up applies a migration.
 func (e *SQLExecutor) Up(migration *Migration, store Store) error {
-	return e.exec(migration.Options.Transaction, func(tx SQLExecer) error {
-		if _, err := tx.Exec(string(migration.UpSQL)); err != nil {
-			return err
-		}
+	return e.UpContext(context.Background(), migration, store)
+}
+
+// UpContext is like Up, but with a context. Cancelling the context aborts the
+// running statement and rolls back the transaction. The time the migration
+// took to run is recorded as its Duration.
+func (e *SQLExecutor) UpContext(ctx context.Context, migration *Migration, store Store) error {
+	start := time.Now()
 
-		return store.Insert(migration, tx)
+	return e.exec(ctx, migration, Up, store, migration.UpSQL, migration.UpFunc, func(tx SQLExecer) error {
+		migration.Duration = time.Since(start)
+		return InsertContext(ctx, store, migration, tx)
 	})
 }
 
 // Down reverses a migrations.
 func (e *SQLExecutor) Down(migration *Migration, store Store) error {
+	return e.DownContext(context.Background(), migration, store)
+}
+
+// DownContext is like Down, but with a context. Cancelling the context aborts
+// the running statement and rolls back the transaction.
+func (e *SQLExecutor) DownContext(ctx context.Context, migration *Migration, store Store) error {
 	if !migration.Reversible() {
 		return IrreversibleError{migration.Version}
 	}
 
-	return e.exec(migration.Options.Transaction, func(tx SQLExecer) error {
-		if _, err := tx.Exec(string(migration.DownSQL)); err != nil {
+	return e.exec(ctx, migration, Down, store, migration.DownSQL, migration.DownFunc, func(tx SQLExecer) error {
+		return RemoveContext(ctx, store, migration, tx)
+	})
+}
+
+// exec runs the migration content, or function for Go migrations, and records
+// the result in the store in one transaction. Migrations that cannot run in a
+// transaction are marked dirty in the store for the time they run, if the
+// store supports it. Failures are returned as a MigrationError.
+func (e *SQLExecutor) exec(ctx context.Context, migration *Migration, direction Direction, store Store, content []byte, fn MigrationFunc, record func(SQLExecer) error) error {
+	rolledBack, err := e.execMigration(ctx, migration, store, content, fn, record)
+	if err == nil {
+		return nil
+	}
+
+	migrationErr := MigrationError{
+		Version:    migration.Version,
+		Path:       migration.Path,
+		Direction:  direction,
+		RolledBack: rolledBack,
+		Err:        err,
+	}
+
+	var statementErr StatementError
+	if errors.As(err, &statementErr) {
+		migrationErr.Statement = &statementErr.Statement
+	}
+
+	return migrationErr
+}
+
+func (e *SQLExecutor) execMigration(ctx context.Context, migration *Migration, store Store, content []byte, fn MigrationFunc, record func(SQLExecer) error) (rolledBack bool, err error) {
+	run := func(execer SQLExecer) error {
+		if fn != nil {
+			return fn(ctx, execer)
+		}
+
+		return e.execStatements(ctx, execer, content)
+	}
+
+	// Prepare the store before any transaction, so it does not need a second
+	// connection while one is held by the transaction.
+	if store, ok := store.(schemaEnsurer); ok {
+		if err := store.ensureSchemaTableExists(ctx); err != nil {
+			return false, err
+		}
+	}
+
+	if !migration.Options.Transaction {
+		// Repeatable migrations have no version to mark dirty. A failed one is
+		// simply applied again.
+		dirtyStore, dirtyTracked := store.(DirtyStore)
+		dirtyTracked = dirtyTracked && !migration.Repeatable
+		if dirtyTracked {
+			if err := dirtyStore.MarkDirty(ctx, migration, e.db); err != nil {
+				return false, err
+			}
+		}
+
+		if err := run(e.db); err != nil {
+			return false, err
+		}
+
+		if !dirtyTracked {
+			return false, record(e.db)
+		}
+
+		// The statements already ran outside of a transaction, so the
+		// migration is not rolled back even if recording it is.
+		_, err := e.transaction(ctx, func(tx SQLExecer) error {
+			if err := dirtyStore.ClearDirty(ctx, migration, tx); err != nil {
+				return err
+			}
+
+			return record(tx)
+		})
+
+		return false, err
+	}
+
+	return e.transaction(ctx, func(tx SQLExecer) error {
+		if err := run(tx); err != nil {
 			return err
 		}
 
-		return store.Remove(migration, tx)
+		return record(tx)
 	})
 }
 
-func (e *SQLExecutor) exec(transaction bool, action func(SQLExecer) error) error {
-	if !transaction {
-		return action(e.db)
+// transaction runs an action in a transaction. If the action fails, the
+// transaction is rolled back and rolledBack tells whether that succeeded. A
+// transaction aborted by its context is rolled back by database/sql.
+func (e *SQLExecutor) transaction(ctx context.Context, action func(SQLExecer) error) (rolledBack bool, err error) {
+	tx, err := beginTx(ctx, e.db)
+	if err != nil {
+		return false, err
 	}
 
-	tx, err := e.db.Begin()
-	if err != nil {
+	if err := action(tx); err != nil {
+		rollbackErr := tx.Rollback()
+		return rollbackErr == nil || rollbackErr == sql.ErrTxDone, err
+	}
+
+	return false, tx.Commit()
+}
+
+// UpContext applies a migration with an executor and a context. If the
+// executor is not a ContextExecutor, the context is only checked before the
+// migration is applied.
+func UpContext(ctx context.Context, executor Executor, migration *Migration, store Store) error {
+	if executor, ok := executor.(ContextExecutor); ok {
+		return executor.UpContext(ctx, migration, store)
+	}
+
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	if err := action(tx); err != nil {
-		defer tx.Rollback()
+	return executor.Up(migration, store)
+}
+
+// DownContext reverts a migration with an executor and a context. If the
+// executor is not a ContextExecutor, the context is only checked before the
+// migration is reverted.
+func DownContext(ctx context.Context, executor Executor, migration *Migration, store Store) error {
+	if executor, ok := executor.(ContextExecutor); ok {
+		return executor.DownContext(ctx, migration, store)
+	}
+
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	return tx.Commit()
+	return executor.Down(migration, store)
 }
 
-// NewSQLExecutor creates an SQLExecutor.
+func (e *SQLExecutor) execStatements(ctx context.Context, execer SQLExecer, content []byte) error {
+	if e.dialect == 0 {
+		_, err := sqlExecerContext(execer).ExecContext(ctx, string(content))
+		return err
+	}
+
+	statements, err := SplitStatements(content, e.dialect)
+	if err != nil {
+		return err
+	}
+
+	for i, statement := range statements {
+		if _, err := sqlExecerContext(execer).ExecContext(ctx, statement.SQL); err != nil {
+			return StatementError{Index: i, Statement: statement, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// NewSQLExecutor creates an SQLExecutor that sends every migration in a
+// single statement.
 func NewSQLExecutor(db SQLTransactor) Executor {
 	return &SQLExecutor{db: db}
 }
+
+// NewPostgreSQLExecutor creates an SQLExecutor for PostgreSQL.
+func NewPostgreSQLExecutor(db SQLTransactor) Executor {
+	return &SQLExecutor{db: db, dialect: PostgreSQL}
+}
+
+// NewMySQLExecutor creates an SQLExecutor for MySQL. It does not need
+// multiStatements=true in the connection string.
+func NewMySQLExecutor(db SQLTransactor) Executor {
+	return &SQLExecutor{db: db, dialect: MySQL}
+}
+
+// NewSQLite3Executor creates an SQLExecutor for SQLite3.
+func NewSQLite3Executor(db SQLTransactor) Executor {
+	return &SQLExecutor{db: db, dialect: SQLite3}
+}
+
+// schemaEnsurer is a store that creates its table on first use.
+type schemaEnsurer interface {
+	ensureSchemaTableExists(context.Context) error
+}
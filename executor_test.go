@@ -1,11 +1,15 @@
 package gloat
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSQLExecutor_Up(t *testing.T) {
@@ -25,6 +29,25 @@ func TestSQLExecutor_Up(t *testing.T) {
 	})
 }
 
+func TestSQLExecutor_Up_Duration(t *testing.T) {
+	exe := NewSQLExecutor(db)
+
+	migration := &Migration{
+		UpFunc: func(context.Context, SQLExecer) error {
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		},
+		Version: 20180101000000,
+		Options: DefaultMigrationOptions(),
+	}
+
+	cleanState(func() {
+		err := exe.Up(migration, new(testingStore))
+		assert.Nil(t, err)
+		assert.True(t, migration.Duration >= 10*time.Millisecond)
+	})
+}
+
 func TestSQLExecutor_Up_Broken(t *testing.T) {
 	td := filepath.Join(dbSrc, "20180920181906_migration_with_an_error")
 
@@ -72,3 +95,275 @@ func TestSQLExecutor_Down_Broken(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+func TestSQLExecutor_UpContext_Cancelled(t *testing.T) {
+	td := filepath.Join(dbSrc, "20170329154959_introduce_domain_model")
+
+	exe := NewSQLExecutor(db).(ContextExecutor)
+
+	migration, err := MigrationFromBytes(td, ioutil.ReadFile)
+	assert.Nil(t, err)
+
+	dbStore, err := databaseStoreFactory(dbDriver, db)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cleanState(func() {
+		err := exe.UpContext(ctx, migration, dbStore)
+		assert.True(t, errors.Is(err, context.Canceled))
+
+		_, err = db.Exec(`SELECT id FROM users LIMIT 1`)
+		assert.NotNil(t, err)
+
+		migrations, err := dbStore.Collect()
+		assert.Nil(t, err)
+		assert.Len(t, migrations, 0)
+	})
+}
+
+func TestSQLExecutor_DownContext_Cancelled(t *testing.T) {
+	td := filepath.Join(dbSrc, "20170329154959_introduce_domain_model")
+
+	exe := NewSQLExecutor(db).(ContextExecutor)
+
+	migration, err := MigrationFromBytes(td, ioutil.ReadFile)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cleanState(func() {
+		err := exe.Up(migration, new(testingStore))
+		assert.Nil(t, err)
+
+		err = exe.DownContext(ctx, migration, new(testingStore))
+		assert.True(t, errors.Is(err, context.Canceled))
+
+		_, err = db.Exec(`SELECT id FROM users LIMIT 1`)
+		assert.Nil(t, err)
+	})
+}
+
+func TestExecutorContext_Cancelled(t *testing.T) {
+	called := false
+
+	exe := &stubbedExecutor{
+		up: func(*Migration, Store) error {
+			called = true
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, UpContext(ctx, exe, &Migration{}, nil))
+	assert.Equal(t, context.Canceled, DownContext(ctx, exe, &Migration{}, nil))
+	assert.False(t, called)
+}
+
+func TestSQLExecutor_Up_NonTransactional(t *testing.T) {
+	exe := NewSQLExecutor(db)
+
+	migration := &Migration{
+		UpSQL:   []byte(`CREATE TABLE users (id INTEGER PRIMARY KEY NOT NULL)`),
+		Version: 20180905150724,
+		Options: MigrationOptions{Transaction: false},
+	}
+
+	dbStore, err := databaseStoreFactory(dbDriver, db)
+	assert.Nil(t, err)
+
+	cleanState(func() {
+		err := exe.Up(migration, dbStore)
+		assert.Nil(t, err)
+
+		migrations, err := dbStore.Collect()
+		assert.Nil(t, err)
+		assert.Len(t, migrations, 1)
+
+		dirty, err := dbStore.(DirtyStore).CollectDirty(context.Background())
+		assert.Nil(t, err)
+		assert.Len(t, dirty, 0)
+	})
+}
+
+func TestSQLExecutor_Up_NonTransactional_Broken(t *testing.T) {
+	exe := NewSQLExecutor(db)
+
+	migration := &Migration{
+		UpSQL:   []byte(`CREATE TABL users (id INTEGER PRIMARY KEY NOT NULL)`),
+		Version: 20180905150724,
+		Options: MigrationOptions{Transaction: false},
+	}
+
+	dbStore, err := databaseStoreFactory(dbDriver, db)
+	assert.Nil(t, err)
+
+	cleanState(func() {
+		err := exe.Up(migration, dbStore)
+		assert.Error(t, err)
+
+		migrations, err := dbStore.Collect()
+		assert.Nil(t, err)
+		assert.Len(t, migrations, 0)
+
+		dirty, err := dbStore.(DirtyStore).CollectDirty(context.Background())
+		assert.Nil(t, err)
+		require.Len(t, dirty, 1)
+		assert.Equal(t, int64(20180905150724), dirty[0].Version)
+	})
+}
+
+func TestSQLExecutor_Up_GoMigration(t *testing.T) {
+	exe := NewSQLExecutor(db)
+
+	migration := &Migration{
+		UpFunc: func(ctx context.Context, tx SQLExecer) error {
+			_, err := tx.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY NOT NULL)`)
+			return err
+		},
+		Version: 20180101000000,
+		Options: DefaultMigrationOptions(),
+	}
+
+	cleanState(func() {
+		err := exe.Up(migration, new(testingStore))
+		assert.Nil(t, err)
+
+		_, err = db.Exec(`SELECT id FROM users LIMIT 1`)
+		assert.Nil(t, err)
+	})
+}
+
+func TestSQLExecutor_Up_GoMigration_Broken(t *testing.T) {
+	exe := NewSQLExecutor(db)
+
+	expectedErr := errors.New("backfill failed")
+	migration := &Migration{
+		UpFunc: func(ctx context.Context, tx SQLExecer) error {
+			if _, err := tx.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY NOT NULL)`); err != nil {
+				return err
+			}
+
+			return expectedErr
+		},
+		Version: 20180101000000,
+		Options: DefaultMigrationOptions(),
+	}
+
+	cleanState(func() {
+		err := exe.Up(migration, new(testingStore))
+		assert.True(t, errors.Is(err, expectedErr))
+
+		_, err = db.Exec(`SELECT id FROM users LIMIT 1`)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestSQLExecutor_Down_GoMigration_Irreversible(t *testing.T) {
+	exe := NewSQLExecutor(db)
+
+	migration := &Migration{
+		UpFunc:  noopMigrationFunc,
+		Version: 20180101000000,
+		Options: DefaultMigrationOptions(),
+	}
+
+	err := exe.Down(migration, new(testingStore))
+	assert.Equal(t, IrreversibleError{20180101000000}, err)
+}
+
+func TestSQLExecutor_Up_Statements(t *testing.T) {
+	exe := NewSQLite3Executor(db)
+
+	migration := &Migration{
+		Version: 20180920181906,
+		UpSQL:   []byte("CREATE TABLE users (id INTEGER);\n\nCREATE TABL posts (id INTEGER);\n"),
+		Options: DefaultMigrationOptions(),
+	}
+
+	cleanState(func() {
+		err := exe.Up(migration, new(testingStore))
+
+		var statementErr StatementError
+		require.True(t, errors.As(err, &statementErr))
+		assert.Equal(t, 1, statementErr.Index)
+		assert.Equal(t, 3, statementErr.Statement.Line)
+		assert.Equal(t, "CREATE TABL posts (id INTEGER)", statementErr.Statement.SQL)
+
+		// The first statement is rolled back with the transaction.
+		_, err = db.Exec(`SELECT id FROM users LIMIT 1`)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestSQLExecutor_Up_MigrationError(t *testing.T) {
+	td := filepath.Join(dbSrc, "20180920181906_migration_with_an_error")
+
+	exe := NewSQLite3Executor(db)
+
+	migration, err := MigrationFromBytes(td, ioutil.ReadFile)
+	assert.Nil(t, err)
+
+	cleanState(func() {
+		err := exe.Up(migration, new(testingStore))
+
+		var migrationErr MigrationError
+		require.True(t, errors.As(err, &migrationErr))
+		assert.Equal(t, int64(20180920181906), migrationErr.Version)
+		assert.Equal(t, td, migrationErr.Path)
+		assert.Equal(t, Up, migrationErr.Direction)
+		assert.True(t, migrationErr.RolledBack)
+		require.NotNil(t, migrationErr.Statement)
+		assert.Equal(t, 1, migrationErr.Statement.Line)
+		assert.Contains(t, err.Error(), "migration 20180920181906 failed going up, rolled back")
+	})
+}
+
+func TestSQLExecutor_Up_MigrationError_NonTransactional(t *testing.T) {
+	exe := NewSQLExecutor(db)
+
+	migration := &Migration{
+		UpSQL:   []byte("CREATE TABL users (id INTEGER)"),
+		Version: 20180101000000,
+		Options: MigrationOptions{Transaction: false},
+	}
+
+	cleanState(func() {
+		err := exe.Up(migration, new(testingStore))
+
+		var migrationErr MigrationError
+		require.True(t, errors.As(err, &migrationErr))
+		assert.False(t, migrationErr.RolledBack)
+		assert.Nil(t, migrationErr.Statement)
+	})
+}
+
+type failingClearDirtyStore struct{ testingDirtyStore }
+
+func (s *failingClearDirtyStore) ClearDirty(context.Context, *Migration, SQLExecer) error {
+	return errors.New("cannot clear dirty mark")
+}
+
+func TestSQLExecutor_Up_MigrationError_NonTransactionalRecord(t *testing.T) {
+	exe := NewSQLExecutor(db)
+
+	migration := &Migration{
+		UpSQL:   []byte("CREATE TABLE users (id INTEGER)"),
+		Version: 20180101000000,
+		Options: MigrationOptions{Transaction: false},
+	}
+
+	cleanState(func() {
+		store := &failingClearDirtyStore{}
+		err := exe.Up(migration, store)
+
+		var migrationErr MigrationError
+		require.True(t, errors.As(err, &migrationErr))
+		assert.False(t, migrationErr.RolledBack)
+		assert.Len(t, store.dirty, 1)
+	})
+}
@@ -1,6 +1,7 @@
 package gloat
 
 import (
+	"context"
 	"database/sql"
 	"time"
 )
@@ -19,16 +20,174 @@ type Gloat struct {
 	// Executor applies migrations and marks the newly applied migration
 	// versions in the Store.
 	Executor Executor
+
+	// Locker is the migration lock taken by WithLock and Migrate, so
+	// concurrent runs cannot apply the same migration twice. Apply and Revert
+	// do not take it on their own. Can be nil, if the migrations are never
+	// run concurrently.
+	Locker Locker
+
+	// Hooks are notified around every migration applied or reverted, in
+	// order. Can be empty.
+	Hooks []Hook
+
+	// HistoryStore is an append-only log every migration applied or reverted
+	// is recorded in, with its outcome. Can be nil.
+	HistoryStore HistoryStore
+
+	// OutOfOrder is what to do with unapplied migrations older than the
+	// latest applied one. They are applied by default.
+	OutOfOrder OutOfOrderPolicy
+
+	// Tag is a free-form identifier recorded with every migration applied,
+	// e.g. the deploy or the release. Can be blank.
+	Tag string
+
+	// Vars are the variables the migrations are rendered with, see
+	// TemplateSource. The migrations are not rendered, if it is nil.
+	Vars VarsProvider
+}
+
+// source returns the Source, rendered with the Vars if there are any.
+func (c *Gloat) source() Source {
+	if c.Vars == nil {
+		return c.Source
+	}
+
+	return NewTemplateSource(c.Source, c.Vars)
+}
+
+// Lock acquires the migration lock. It is a no-op if there is no Locker.
+func (c *Gloat) Lock() error {
+	return c.LockContext(context.Background())
+}
+
+// LockContext is like Lock, but stops waiting for the lock once the context
+// is done.
+func (c *Gloat) LockContext(ctx context.Context) error {
+	if c.Locker == nil {
+		return nil
+	}
+
+	return LockContext(ctx, c.Locker)
+}
+
+// Unlock releases the migration lock. It is a no-op if there is no Locker.
+func (c *Gloat) Unlock() error {
+	if c.Locker == nil {
+		return nil
+	}
+
+	return c.Locker.Unlock()
+}
+
+// WithLock runs fn while holding the migration lock. The error of releasing
+// the lock is returned, if fn itself succeeds.
+func (c *Gloat) WithLock(fn func() error) error {
+	return c.WithLockContext(context.Background(), fn)
+}
+
+// WithLockContext is like WithLock, but stops waiting for the lock once the
+// context is done.
+func (c *Gloat) WithLockContext(ctx context.Context, fn func() error) (err error) {
+	if err := c.LockContext(ctx); err != nil {
+		return err
+	}
+
+	defer func() {
+		if unlockErr := c.Unlock(); err == nil {
+			err = unlockErr
+		}
+	}()
+
+	return fn()
+}
+
+// Migrate applies all of the unapplied migrations while holding the migration
+// lock. It returns the migrations applied, even if an error occurred.
+func (c *Gloat) Migrate() (Migrations, error) {
+	return c.MigrateContext(context.Background())
+}
+
+// MigrateContext is like Migrate, but with a context. Cancelling the context
+// stops before the next migration and aborts the running one.
+func (c *Gloat) MigrateContext(ctx context.Context) (applied Migrations, err error) {
+	err = c.WithLockContext(ctx, func() error {
+		plan, err := c.PlanUpContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		applied, err = c.execute(ctx, plan)
+		return err
+	})
+
+	return
+}
+
+// MigrateTo migrates to a given version while holding the migration lock. It
+// reverts the migrations applied after the version and applies the unapplied
+// ones up to and including it, see PlanTo. It returns the migrations run, even
+// if an error occurred.
+func (c *Gloat) MigrateTo(version int64) (Migrations, error) {
+	return c.MigrateToContext(context.Background(), version)
+}
+
+// MigrateToContext is like MigrateTo, but with a context.
+func (c *Gloat) MigrateToContext(ctx context.Context, version int64) (executed Migrations, err error) {
+	err = c.WithLockContext(ctx, func() error {
+		plan, err := c.PlanToContext(ctx, version)
+		if err != nil {
+			return err
+		}
+
+		executed, err = c.execute(ctx, plan)
+		return err
+	})
+
+	return
+}
+
+// Redo reverts the last n applied migrations and applies them again while
+// holding the migration lock, see PlanRedo. Nothing is run if any of them is
+// irreversible. It returns the migrations run, even if an error occurred.
+func (c *Gloat) Redo(n int) (Migrations, error) {
+	return c.RedoContext(context.Background(), n)
+}
+
+// RedoContext is like Redo, but with a context.
+func (c *Gloat) RedoContext(ctx context.Context, n int) (executed Migrations, err error) {
+	err = c.WithLockContext(ctx, func() error {
+		plan, err := c.PlanRedoContext(ctx, n)
+		if err != nil {
+			return err
+		}
+
+		executed, err = c.execute(ctx, plan)
+		return err
+	})
+
+	return
 }
 
 // AppliedAfter returns migrations that were applied after a given version tag
 func (c *Gloat) AppliedAfter(version int64) (Migrations, error) {
-	return AppliedAfter(c.Store, c.Source, version)
+	return c.AppliedAfterContext(context.Background(), version)
+}
+
+// AppliedAfterContext is like AppliedAfter, but with a context.
+func (c *Gloat) AppliedAfterContext(ctx context.Context, version int64) (Migrations, error) {
+	return AppliedAfterContext(ctx, c.Store, c.source(), version)
 }
 
 // Present returns all available migrations.
 func (c *Gloat) Present() (Migrations, error) {
-	migrations, err := c.Source.Collect()
+	return c.PresentContext(context.Background())
+}
+
+// PresentContext is like Present, but with a context.
+func (c *Gloat) PresentContext(ctx context.Context) (Migrations, error) {
+	migrations, err := CollectContext(ctx, c.source())
 	if err != nil {
 		return nil, err
 	}
@@ -38,12 +197,22 @@ func (c *Gloat) Present() (Migrations, error) {
 
 // Unapplied returns the unapplied migrations in the current gloat.
 func (c *Gloat) Unapplied() (Migrations, error) {
-	return UnappliedMigrations(c.Store, c.Source)
+	return c.UnappliedContext(context.Background())
+}
+
+// UnappliedContext is like Unapplied, but with a context.
+func (c *Gloat) UnappliedContext(ctx context.Context) (Migrations, error) {
+	return UnappliedMigrationsContext(ctx, c.Store, c.source())
 }
 
 // Latest returns the latest migration in the source.
 func (c *Gloat) Latest() (*Migration, error) {
-	availableMigrations, err := c.Source.Collect()
+	return c.LatestContext(context.Background())
+}
+
+// LatestContext is like Latest, but with a context.
+func (c *Gloat) LatestContext(ctx context.Context) (*Migration, error) {
+	availableMigrations, err := CollectContext(ctx, c.source())
 	if err != nil {
 		return nil, err
 	}
@@ -58,7 +227,12 @@ func (c *Gloat) Latest() (*Migration, error) {
 // This is the case when the last applied migration is no longer available from
 // the source or there are no migrations to begin with.
 func (c *Gloat) Current() (*Migration, error) {
-	appliedMigrations, err := c.Store.Collect()
+	return c.CurrentContext(context.Background())
+}
+
+// CurrentContext is like Current, but with a context.
+func (c *Gloat) CurrentContext(ctx context.Context) (*Migration, error) {
+	appliedMigrations, err := CollectContext(ctx, c.Store)
 	if err != nil {
 		return nil, err
 	}
@@ -68,7 +242,7 @@ func (c *Gloat) Current() (*Migration, error) {
 		return nil, nil
 	}
 
-	availableMigrations, err := c.Source.Collect()
+	availableMigrations, err := CollectContext(ctx, c.source())
 	if err != nil {
 		return nil, err
 	}
@@ -85,15 +259,305 @@ func (c *Gloat) Current() (*Migration, error) {
 	return nil, nil
 }
 
+// Verify returns the applied migrations whose content in the source changed
+// since they were applied.
+func (c *Gloat) Verify() (Migrations, error) {
+	return c.VerifyContext(context.Background())
+}
+
+// VerifyContext is like Verify, but with a context.
+func (c *Gloat) VerifyContext(ctx context.Context) (Migrations, error) {
+	return ChangedMigrationsContext(ctx, c.Store, c.source())
+}
+
 // Apply applies a migration.
 func (c *Gloat) Apply(migration *Migration) error {
-	migration.AppliedAt = time.Now().UTC()
-	return c.Executor.Up(migration, c.Store)
+	return c.ApplyContext(context.Background(), migration)
+}
+
+// ApplyContext is like Apply, but with a context. Cancelling the context
+// aborts the migration. The Hooks are notified around it and the outcome is
+// recorded in the HistoryStore.
+func (c *Gloat) ApplyContext(ctx context.Context, migration *Migration) error {
+	if err := c.checkDirty(ctx); err != nil {
+		return err
+	}
+
+	return c.runHooks(ctx, migration, Up, func() error {
+		c.stamp(migration)
+
+		return c.record(ctx, migration, ActionApply, func() error {
+			return UpContext(ctx, c.Executor, migration, c.Store)
+		})
+	})
 }
 
 // Revert rollbacks a migration.
 func (c *Gloat) Revert(migration *Migration) error {
-	return c.Executor.Down(migration, c.Store)
+	return c.RevertContext(context.Background(), migration)
+}
+
+// RevertContext is like Revert, but with a context. Cancelling the context
+// aborts the migration. The Hooks are notified around it and the outcome is
+// recorded in the HistoryStore.
+func (c *Gloat) RevertContext(ctx context.Context, migration *Migration) error {
+	if err := c.checkDirty(ctx); err != nil {
+		return err
+	}
+
+	return c.runHooks(ctx, migration, Down, func() error {
+		return c.record(ctx, migration, ActionRevert, func() error {
+			return DownContext(ctx, c.Executor, migration, c.Store)
+		})
+	})
+}
+
+// History returns the recorded runs selected by the filter, oldest first. It
+// is always empty, if there is no HistoryStore.
+func (c *Gloat) History(filter HistoryFilter) ([]*HistoryEntry, error) {
+	return c.HistoryContext(context.Background(), filter)
+}
+
+// HistoryContext is like History, but with a context.
+func (c *Gloat) HistoryContext(ctx context.Context, filter HistoryFilter) ([]*HistoryEntry, error) {
+	if c.HistoryStore == nil {
+		return nil, nil
+	}
+
+	return c.HistoryStore.History(ctx, filter)
+}
+
+// Dirty returns the migrations that failed halfway while running outside of a
+// transaction. It is always empty, if the Store is not a DirtyStore.
+func (c *Gloat) Dirty() (Migrations, error) {
+	return c.DirtyContext(context.Background())
+}
+
+// DirtyContext is like Dirty, but with a context.
+func (c *Gloat) DirtyContext(ctx context.Context) (Migrations, error) {
+	store, ok := c.Store.(DirtyStore)
+	if !ok {
+		return nil, nil
+	}
+
+	return store.CollectDirty(ctx)
+}
+
+// Force resolves a dirty migration by recording it as applied, without
+// executing it. Use it after making sure the migration is fully applied.
+func (c *Gloat) Force(version int64) error {
+	return c.ForceContext(context.Background(), version)
+}
+
+// ForceContext is like Force, but with a context.
+func (c *Gloat) ForceContext(ctx context.Context, version int64) error {
+	migrations, err := CollectContext(ctx, c.source())
+	if err != nil {
+		return err
+	}
+
+	migration := migrations.Find(version)
+	if migration == nil {
+		return ErrNotFound
+	}
+
+	if store, ok := c.Store.(DirtyStore); ok {
+		if err := store.ClearDirty(ctx, migration, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := RemoveContext(ctx, c.Store, migration, nil); err != nil {
+		return err
+	}
+
+	c.stamp(migration)
+	return InsertContext(ctx, c.Store, migration, nil)
+}
+
+// Baseline records every migration in the source up to and including a
+// version as applied, without executing them. Use it to adopt gloat on a
+// database whose schema is already at that version. It refuses to baseline a
+// store that already has applied migrations, see ForceBaseline. It returns the
+// migrations recorded.
+func (c *Gloat) Baseline(version int64) (Migrations, error) {
+	return c.BaselineContext(context.Background(), version)
+}
+
+// BaselineContext is like Baseline, but with a context.
+func (c *Gloat) BaselineContext(ctx context.Context, version int64) (Migrations, error) {
+	return c.baseline(ctx, version, false)
+}
+
+// ForceBaseline is like Baseline, but baselines a store with applied
+// migrations too. The migrations already applied are left as they are.
+func (c *Gloat) ForceBaseline(version int64) (Migrations, error) {
+	return c.ForceBaselineContext(context.Background(), version)
+}
+
+// ForceBaselineContext is like ForceBaseline, but with a context.
+func (c *Gloat) ForceBaselineContext(ctx context.Context, version int64) (Migrations, error) {
+	return c.baseline(ctx, version, true)
+}
+
+func (c *Gloat) baseline(ctx context.Context, version int64, force bool) (Migrations, error) {
+	migrations, err := CollectContext(ctx, c.source())
+	if err != nil {
+		return nil, err
+	}
+
+	if migrations.Find(version) == nil {
+		return nil, ErrNotFound
+	}
+
+	appliedMigrations, err := CollectContext(ctx, c.Store)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(appliedMigrations) != 0 && !force {
+		return nil, ErrNotEmpty
+	}
+
+	migrations.Sort()
+
+	var baselined Migrations
+	for _, migration := range migrations {
+		if migration.Version > version || appliedMigrations.Find(migration.Version) != nil {
+			continue
+		}
+
+		c.stamp(migration)
+
+		err := c.record(ctx, migration, ActionBaseline, func() error {
+			return InsertContext(ctx, c.Store, migration, nil)
+		})
+		if err != nil {
+			return baselined, err
+		}
+
+		baselined = append(baselined, migration)
+	}
+
+	return baselined, nil
+}
+
+// MarkApplied records a migration as applied, without executing it. Use it
+// after applying the migration by hand. It returns ErrAlreadyApplied, if the
+// migration is already recorded as applied.
+func (c *Gloat) MarkApplied(version int64) error {
+	return c.MarkAppliedContext(context.Background(), version)
+}
+
+// MarkAppliedContext is like MarkApplied, but with a context.
+func (c *Gloat) MarkAppliedContext(ctx context.Context, version int64) error {
+	migration, applied, err := c.lookup(ctx, version)
+	if err != nil {
+		return err
+	}
+
+	if applied {
+		return ErrAlreadyApplied
+	}
+
+	c.stamp(migration)
+
+	return c.record(ctx, migration, ActionMark, func() error {
+		return InsertContext(ctx, c.Store, migration, nil)
+	})
+}
+
+// MarkUnapplied removes the record of an applied migration, without reverting
+// it. Use it after reverting the migration by hand. It returns ErrNotApplied,
+// if the migration is not recorded as applied.
+func (c *Gloat) MarkUnapplied(version int64) error {
+	return c.MarkUnappliedContext(context.Background(), version)
+}
+
+// MarkUnappliedContext is like MarkUnapplied, but with a context.
+func (c *Gloat) MarkUnappliedContext(ctx context.Context, version int64) error {
+	migration, applied, err := c.lookup(ctx, version)
+	if err != nil {
+		return err
+	}
+
+	if !applied {
+		return ErrNotApplied
+	}
+
+	return c.record(ctx, migration, ActionUnmark, func() error {
+		return RemoveContext(ctx, c.Store, migration, nil)
+	})
+}
+
+// lookup finds a migration in the source and tells whether it is applied. It
+// returns ErrNotFound, if the migration is not in the source.
+func (c *Gloat) lookup(ctx context.Context, version int64) (*Migration, bool, error) {
+	migrations, err := CollectContext(ctx, c.source())
+	if err != nil {
+		return nil, false, err
+	}
+
+	migration := migrations.Find(version)
+	if migration == nil {
+		return nil, false, ErrNotFound
+	}
+
+	appliedMigrations, err := CollectContext(ctx, c.Store)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return migration, appliedMigrations.Find(version) != nil, nil
+}
+
+// CleanDirty removes the dirty records, leaving the migrations unapplied. Use
+// it after making sure the dirty migrations left nothing behind.
+func (c *Gloat) CleanDirty() (Migrations, error) {
+	return c.CleanDirtyContext(context.Background())
+}
+
+// CleanDirtyContext is like CleanDirty, but with a context.
+func (c *Gloat) CleanDirtyContext(ctx context.Context) (Migrations, error) {
+	store, ok := c.Store.(DirtyStore)
+	if !ok {
+		return nil, nil
+	}
+
+	migrations, err := store.CollectDirty(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, migration := range migrations {
+		if err := store.ClearDirty(ctx, migration, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return migrations, nil
+}
+
+// stamp records when, where and by whom a migration is applied.
+func (c *Gloat) stamp(migration *Migration) {
+	migration.AppliedAt = time.Now().UTC()
+	migration.Host = currentHost()
+	migration.AppliedBy = currentUser()
+	migration.GloatVersion = version()
+	migration.Tag = c.Tag
+}
+
+func (c *Gloat) checkDirty(ctx context.Context) error {
+	migrations, err := c.DirtyContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(migrations) != 0 {
+		return DirtyError{migrations[0].Version}
+	}
+
+	return nil
 }
 
 // SQLExecer is an interface compatible with sql.Tx.Exec. Can be passed as
@@ -103,6 +567,15 @@ type SQLExecer interface {
 	Query(query string, args ...interface{}) (*sql.Rows, error)
 }
 
+// SQLExecerContext is an SQLExecer that can be cancelled through a context.
+// Both sql.Tx and sql.DB satisfy it.
+type SQLExecerContext interface {
+	SQLExecer
+
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
 // SQLTransactor is usually satisfied by *sql.DB, but can be used by wrappers
 // around it.
 type SQLTransactor interface {
@@ -110,3 +583,56 @@ type SQLTransactor interface {
 
 	Begin() (*sql.Tx, error)
 }
+
+// SQLTransactorContext is an SQLTransactor that can be cancelled through a
+// context. Usually satisfied by *sql.DB.
+type SQLTransactorContext interface {
+	SQLTransactor
+	SQLExecerContext
+
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// sqlExecerContext returns the execer itself, if it is an SQLExecerContext.
+// Otherwise, the context is only checked before every statement.
+func sqlExecerContext(execer SQLExecer) SQLExecerContext {
+	if execer, ok := execer.(SQLExecerContext); ok {
+		return execer
+	}
+
+	return sqlExecerWithoutContext{execer}
+}
+
+// beginTx starts a transaction with a context. If the transactor is not an
+// SQLTransactorContext, the context is only checked before the transaction.
+func beginTx(ctx context.Context, db SQLTransactor) (*sql.Tx, error) {
+	if db, ok := db.(SQLTransactorContext); ok {
+		return db.BeginTx(ctx, nil)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return db.Begin()
+}
+
+type sqlExecerWithoutContext struct {
+	SQLExecer
+}
+
+func (e sqlExecerWithoutContext) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return e.Exec(query, args...)
+}
+
+func (e sqlExecerWithoutContext) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return e.Query(query, args...)
+}
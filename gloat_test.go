@@ -1,8 +1,10 @@
 package gloat
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"net/url"
 	"os"
 	"strings"
@@ -32,6 +34,29 @@ func (s *testingStore) Collect() (Migrations, error)                   { return
 func (s *testingStore) Insert(migration *Migration, _ SQLExecer) error { return nil }
 func (s *testingStore) Remove(migration *Migration, _ SQLExecer) error { return nil }
 
+type testingDirtyStore struct {
+	testingStore
+	dirty Migrations
+}
+
+func (s *testingDirtyStore) MarkDirty(_ context.Context, m *Migration, _ SQLExecer) error {
+	s.dirty = append(s.dirty, m)
+	return nil
+}
+
+func (s *testingDirtyStore) ClearDirty(_ context.Context, m *Migration, _ SQLExecer) error {
+	var dirty Migrations
+	for _, d := range s.dirty {
+		if d.Version != m.Version {
+			dirty = append(dirty, d)
+		}
+	}
+	s.dirty = dirty
+	return nil
+}
+
+func (s *testingDirtyStore) CollectDirty(context.Context) (Migrations, error) { return s.dirty, nil }
+
 type testingExecutor struct{}
 
 func (e *testingExecutor) Up(*Migration, Store) error   { return nil }
@@ -58,9 +83,19 @@ func (e *stubbedExecutor) Down(m *Migration, s Store) error {
 	return nil
 }
 
+type testingLocker struct {
+	locked   bool
+	unlocked bool
+}
+
+func (l *testingLocker) Lock() error   { l.locked = true; return nil }
+func (l *testingLocker) Unlock() error { l.unlocked = true; return nil }
+
 func cleanState(fn func()) error {
 	_, err := db.Exec(`
 		DROP TABLE IF EXISTS schema_migrations;	
+		DROP TABLE IF EXISTS schema_migrations_history;
+		DROP TABLE IF EXISTS schema_migrations_repeatable;
 		DROP TABLE IF EXISTS users;	
 	`)
 
@@ -73,14 +108,14 @@ func cleanState(fn func()) error {
 	return nil
 }
 
-func databaseStoreFactory(driver string, db *sql.DB) (Store, error) {
+func databaseStoreFactory(driver string, db *sql.DB, options ...TableOption) (Store, error) {
 	switch driver {
 	case "postgres", "postgresql":
-		return NewPostgreSQLStore(db), nil
+		return NewPostgreSQLStore(db, options...), nil
 	case "mysql":
-		return NewMySQLStore(db), nil
+		return NewMySQLStore(db, options...), nil
 	case "sqlite", "sqlite3":
-		return NewMySQLStore(db), nil
+		return NewSQLite3Store(db, options...), nil
 	}
 
 	return nil, errors.New("unsupported database driver " + driver)
@@ -196,6 +231,160 @@ func TestCurrent_Nil(t *testing.T) {
 	assert.Nil(t, migration)
 }
 
+func TestVerify(t *testing.T) {
+	gl.Source = &testingStore{
+		applied: Migrations{
+			&Migration{Version: 20170329154959, Checksum: "a"},
+			&Migration{Version: 20180329154959, Checksum: "b"},
+			&Migration{Version: 20190329154959, Checksum: "c"},
+		},
+	}
+	gl.Store = &testingStore{
+		applied: Migrations{
+			&Migration{Version: 20170329154959, Checksum: "a"},
+			&Migration{Version: 20180329154959, Checksum: "changed"},
+			&Migration{Version: 20190329154959},
+		},
+	}
+
+	migrations, err := gl.Verify()
+	assert.Nil(t, err)
+
+	require.Len(t, migrations, 1)
+	assert.Equal(t, int64(20180329154959), migrations[0].Version)
+}
+
+func TestApply_Dirty(t *testing.T) {
+	called := false
+
+	gl.Store = &testingDirtyStore{dirty: Migrations{&Migration{Version: 20180905150724}}}
+	gl.Executor = &stubbedExecutor{
+		up: func(*Migration, Store) error {
+			called = true
+			return nil
+		},
+	}
+
+	err := gl.Apply(&Migration{})
+	assert.Equal(t, DirtyError{20180905150724}, err)
+	assert.False(t, called)
+
+	err = gl.Revert(&Migration{})
+	assert.Equal(t, DirtyError{20180905150724}, err)
+}
+
+func TestForce(t *testing.T) {
+	gl.Source = NewFileSystemSource("testdata/migrations")
+
+	store := &testingDirtyStore{dirty: Migrations{&Migration{Version: 20180905150724}}}
+	gl.Store = store
+
+	err := gl.Force(20180905150724)
+	assert.Nil(t, err)
+
+	migrations, err := gl.Dirty()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 0)
+
+	err = gl.Force(20000101000000)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestBaseline(t *testing.T) {
+	cleanState(func() {
+		store, err := databaseStoreFactory(dbDriver, db)
+		require.Nil(t, err)
+
+		gl.Source = NewFileSystemSource("testdata/migrations")
+		gl.Store = store
+
+		migrations, err := gl.Baseline(20170511172647)
+		require.Nil(t, err)
+		require.Len(t, migrations, 2)
+		assert.Equal(t, int64(20170329154959), migrations[0].Version)
+		assert.Equal(t, int64(20170511172647), migrations[1].Version)
+
+		applied, err := store.Collect()
+		require.Nil(t, err)
+		assert.Len(t, applied, 2)
+
+		// Nothing was executed.
+		_, err = db.Exec("SELECT * FROM users")
+		assert.NotNil(t, err)
+
+		_, err = gl.Baseline(20180905150724)
+		assert.Equal(t, ErrNotEmpty, err)
+
+		migrations, err = gl.ForceBaseline(20180905150724)
+		require.Nil(t, err)
+		require.Len(t, migrations, 1)
+		assert.Equal(t, int64(20180905150724), migrations[0].Version)
+
+		_, err = gl.Baseline(20000101000000)
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
+
+func TestMarkApplied(t *testing.T) {
+	cleanState(func() {
+		store, err := databaseStoreFactory(dbDriver, db)
+		require.Nil(t, err)
+
+		history := &testingHistoryStore{}
+
+		gl.Source = NewFileSystemSource("testdata/migrations")
+		gl.Store = store
+		gl.HistoryStore = history
+		defer func() { gl.HistoryStore = nil }()
+
+		err = gl.MarkApplied(20170329154959)
+		require.Nil(t, err)
+
+		applied, err := store.Collect()
+		require.Nil(t, err)
+		require.Len(t, applied, 1)
+		assert.Equal(t, int64(20170329154959), applied[0].Version)
+
+		// Nothing was executed.
+		_, err = db.Exec("SELECT * FROM users")
+		assert.NotNil(t, err)
+
+		err = gl.MarkApplied(20170329154959)
+		assert.Equal(t, ErrAlreadyApplied, err)
+
+		err = gl.MarkApplied(20000101000000)
+		assert.Equal(t, ErrNotFound, err)
+
+		err = gl.MarkUnapplied(20170329154959)
+		require.Nil(t, err)
+
+		applied, err = store.Collect()
+		require.Nil(t, err)
+		assert.Len(t, applied, 0)
+
+		err = gl.MarkUnapplied(20170329154959)
+		assert.Equal(t, ErrNotApplied, err)
+
+		require.Len(t, history.entries, 2)
+		assert.Equal(t, ActionMark, history.entries[0].Action)
+		assert.Equal(t, ActionUnmark, history.entries[1].Action)
+		assert.True(t, history.entries[1].Success)
+	})
+}
+
+func TestCleanDirty(t *testing.T) {
+	gl.Store = &testingDirtyStore{dirty: Migrations{&Migration{Version: 20180905150724}}}
+
+	migrations, err := gl.CleanDirty()
+	assert.Nil(t, err)
+	require.Len(t, migrations, 1)
+	assert.Equal(t, int64(20180905150724), migrations[0].Version)
+
+	migrations, err = gl.Dirty()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 0)
+}
+
 func TestApply(t *testing.T) {
 	called := false
 
@@ -214,6 +403,20 @@ func TestApply(t *testing.T) {
 	assert.NotEmpty(t, m.AppliedAt)
 }
 
+func TestApply_Metadata(t *testing.T) {
+	m := &Migration{}
+	gl.Store = &testingStore{}
+	gl.Executor = &testingExecutor{}
+	gl.Tag = "release-42"
+	defer func() { gl.Tag = "" }()
+
+	assert.Nil(t, gl.Apply(m))
+
+	assert.Equal(t, "release-42", m.Tag)
+	assert.Equal(t, currentHost(), m.Host)
+	assert.NotEmpty(t, m.AppliedBy)
+}
+
 func TestRevert(t *testing.T) {
 	called := false
 
@@ -230,6 +433,276 @@ func TestRevert(t *testing.T) {
 	assert.True(t, called)
 }
 
+func TestLock(t *testing.T) {
+	locker := &testingLocker{}
+
+	gl.Locker = locker
+	defer func() { gl.Locker = nil }()
+
+	assert.Nil(t, gl.Lock())
+	assert.True(t, locker.locked)
+
+	assert.Nil(t, gl.Unlock())
+	assert.True(t, locker.unlocked)
+}
+
+func TestWithLock(t *testing.T) {
+	locker := &testingLocker{}
+
+	gl.Locker = locker
+	defer func() { gl.Locker = nil }()
+
+	called := false
+	err := gl.WithLock(func() error {
+		called = true
+		assert.True(t, locker.locked)
+		assert.False(t, locker.unlocked)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	assert.True(t, called)
+	assert.True(t, locker.unlocked)
+}
+
+func TestWithLock_Error(t *testing.T) {
+	locker := &testingLocker{}
+
+	gl.Locker = locker
+	defer func() { gl.Locker = nil }()
+
+	expectedErr := errors.New("boom")
+	err := gl.WithLock(func() error { return expectedErr })
+	assert.Equal(t, expectedErr, err)
+
+	assert.True(t, locker.unlocked)
+}
+
+func TestMigrate(t *testing.T) {
+	locker := &testingLocker{}
+
+	var applied []int64
+
+	gl.Locker = locker
+	gl.Source = NewFileSystemSource("testdata/migrations")
+	gl.Store = &testingStore{applied: Migrations{&Migration{Version: 20170329154959}}}
+	gl.Executor = &stubbedExecutor{
+		up: func(m *Migration, _ Store) error {
+			assert.True(t, locker.locked)
+			applied = append(applied, m.Version)
+			return nil
+		},
+	}
+	defer func() { gl.Locker = nil }()
+
+	migrations, err := gl.Migrate()
+	assert.Nil(t, err)
+
+	assert.Len(t, migrations, 3)
+	assert.Equal(t, []int64{20170511172647, 20180905150724, 20180920181906}, applied)
+	assert.True(t, locker.unlocked)
+}
+
+func TestMigrateTo(t *testing.T) {
+	locker := &testingLocker{}
+
+	var run []string
+
+	gl.Locker = locker
+	gl.Source = NewFileSystemSource("testdata/migrations")
+	gl.Store = &testingStore{
+		applied: Migrations{
+			&Migration{Version: 20180905150724},
+			&Migration{Version: 20170329154959},
+		},
+	}
+	gl.Executor = &stubbedExecutor{
+		up: func(m *Migration, _ Store) error {
+			run = append(run, fmt.Sprintf("up %d", m.Version))
+			return nil
+		},
+		down: func(m *Migration, _ Store) error {
+			run = append(run, fmt.Sprintf("down %d", m.Version))
+			return nil
+		},
+	}
+	defer func() { gl.Locker = nil }()
+
+	migrations, err := gl.MigrateTo(20170511172647)
+	assert.Nil(t, err)
+
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, []string{"down 20180905150724", "up 20170511172647"}, run)
+	assert.True(t, locker.unlocked)
+}
+
+func TestRedo(t *testing.T) {
+	locker := &testingLocker{}
+
+	var run []string
+
+	gl.Locker = locker
+	gl.Source = &testingStore{
+		applied: Migrations{
+			&Migration{Version: 20190329154959, DownSQL: []byte("DROP TABLE b;")},
+			&Migration{Version: 20180329154959, DownSQL: []byte("DROP TABLE a;")},
+			&Migration{Version: 20170329154959},
+		},
+	}
+	gl.Store = &testingStore{
+		applied: Migrations{
+			&Migration{Version: 20170329154959},
+			&Migration{Version: 20180329154959},
+			&Migration{Version: 20190329154959},
+		},
+	}
+	gl.Executor = &stubbedExecutor{
+		up: func(m *Migration, _ Store) error {
+			run = append(run, fmt.Sprintf("up %d", m.Version))
+			return nil
+		},
+		down: func(m *Migration, _ Store) error {
+			run = append(run, fmt.Sprintf("down %d", m.Version))
+			return nil
+		},
+	}
+	defer func() { gl.Locker = nil }()
+
+	migrations, err := gl.Redo(2)
+	assert.Nil(t, err)
+
+	assert.Len(t, migrations, 4)
+	assert.Equal(t, []string{
+		"down 20190329154959",
+		"down 20180329154959",
+		"up 20180329154959",
+		"up 20190329154959",
+	}, run)
+	assert.True(t, locker.unlocked)
+
+	run = nil
+
+	_, err = gl.Redo(3)
+	assert.Equal(t, IrreversibleError{20170329154959}, err)
+	assert.Nil(t, run)
+}
+
+func TestLockContext_Cancelled(t *testing.T) {
+	locker := &testingLocker{}
+
+	gl.Locker = locker
+	defer func() { gl.Locker = nil }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, gl.LockContext(ctx))
+	assert.False(t, locker.locked)
+}
+
+func TestApplyContext_Cancelled(t *testing.T) {
+	called := false
+
+	gl.Store = &testingStore{}
+	gl.Executor = &stubbedExecutor{
+		up: func(*Migration, Store) error {
+			called = true
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := gl.ApplyContext(ctx, &Migration{})
+	assert.Equal(t, context.Canceled, err)
+	assert.False(t, called)
+}
+
+func TestRevertContext_Cancelled(t *testing.T) {
+	called := false
+
+	gl.Store = &testingStore{}
+	gl.Executor = &stubbedExecutor{
+		down: func(*Migration, Store) error {
+			called = true
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := gl.RevertContext(ctx, &Migration{})
+	assert.Equal(t, context.Canceled, err)
+	assert.False(t, called)
+}
+
+func TestUnappliedContext_Cancelled(t *testing.T) {
+	gl.Source = NewFileSystemSource("testdata/migrations")
+	gl.Store = &testingStore{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	migrations, err := gl.UnappliedContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Len(t, migrations, 0)
+}
+
+func TestMigrateContext_Cancelled(t *testing.T) {
+	locker := &testingLocker{}
+	called := false
+
+	gl.Locker = locker
+	gl.Source = NewFileSystemSource("testdata/migrations")
+	gl.Store = &testingStore{}
+	gl.Executor = &stubbedExecutor{
+		up: func(*Migration, Store) error {
+			called = true
+			return nil
+		},
+	}
+	defer func() { gl.Locker = nil }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	migrations, err := gl.MigrateContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Len(t, migrations, 0)
+	assert.False(t, called)
+}
+
+func TestStoreContext_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := CollectContext(ctx, &testingStore{})
+	assert.Equal(t, context.Canceled, err)
+
+	assert.Equal(t, context.Canceled, InsertContext(ctx, &testingStore{}, &Migration{}, nil))
+	assert.Equal(t, context.Canceled, RemoveContext(ctx, &testingStore{}, &Migration{}, nil))
+}
+
+func TestSQLExecerContext_Fallback(t *testing.T) {
+	execer := sqlExecerContext(struct{ SQLExecer }{db})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := execer.ExecContext(ctx, `SELECT 1`)
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = execer.ExecContext(context.Background(), `SELECT 1`)
+	assert.Nil(t, err)
+}
+
+func TestLock_Nil(t *testing.T) {
+	assert.Nil(t, gl.Lock())
+	assert.Nil(t, gl.Unlock())
+}
+
 func init() {
 	gl = Gloat{
 		Source:   NewFileSystemSource("testdata/migrations"),
@@ -239,6 +712,15 @@ func init() {
 	dbURL = os.Getenv("DATABASE_URL")
 	dbSrc = os.Getenv("DATABASE_SRC")
 
+	// Without a database given, run against an in-memory SQLite3 one, so the
+	// tests need no external services.
+	if dbURL == "" {
+		dbURL = "sqlite3://:memory:"
+	}
+	if dbSrc == "" {
+		dbSrc = "testdata/migrations"
+	}
+
 	{
 		u, err := url.Parse(dbURL)
 		if err != nil {
@@ -270,5 +752,11 @@ func init() {
 		if err := db.Ping(); err != nil {
 			panic(err)
 		}
+
+		// Every connection to an in-memory SQLite3 database opens a new,
+		// empty one. Keep a single connection, so all tests share it.
+		if dbDriver == "sqlite3" || dbDriver == "sqlite" {
+			db.SetMaxOpenConns(1)
+		}
 	}
 }
@@ -0,0 +1,81 @@
+package gloat
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// RegisteredMigrations is the GoSource the Register function adds migrations
+// to.
+var RegisteredMigrations = &GoSource{}
+
+// Register registers a Go migration in RegisteredMigrations. It is meant to be
+// called from init functions and panics if the version is already registered.
+func Register(version int64, name string, up, down MigrationFunc) {
+	RegisteredMigrations.Register(version, name, up, down)
+}
+
+// GoSource is a source of migrations written in Go. Useful for data
+// migrations that need Go logic and cannot be written in SQL. Combine it with
+// a FileSystemSource through NewMultiSource, to order both kinds by version.
+type GoSource struct {
+	mu         sync.Mutex
+	migrations Migrations
+}
+
+// Register registers a Go migration. The down function can be nil for
+// irreversible migrations. It panics if the version is already registered.
+func (s *GoSource) Register(version int64, name string, up, down MigrationFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if up == nil {
+		panic(fmt.Sprintf("gloat: Register up function of migration %d is nil", version))
+	}
+
+	for _, migration := range s.migrations {
+		if migration.Version == version {
+			panic(fmt.Sprintf("gloat: Register called twice for migration %d", version))
+		}
+	}
+
+	s.migrations = append(s.migrations, &Migration{
+		UpFunc:   up,
+		DownFunc: down,
+		Path:     generateMigrationPath(version, name),
+		Version:  version,
+		Options:  DefaultMigrationOptions(),
+	})
+}
+
+// Collect builds the registered Go migrations.
+func (s *GoSource) Collect() (Migrations, error) {
+	return s.CollectContext(context.Background())
+}
+
+// CollectContext is like Collect, but with a context.
+func (s *GoSource) CollectContext(ctx context.Context) (Migrations, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	migrations := make(Migrations, 0, len(s.migrations))
+	for _, registered := range s.migrations {
+		migration := *registered
+		migrations = append(migrations, &migration)
+	}
+
+	migrations.Sort()
+
+	return migrations, nil
+}
+
+// NewGoSource creates a new source of Go migrations. Use the Register method
+// to add migrations to it.
+func NewGoSource() *GoSource {
+	return &GoSource{}
+}
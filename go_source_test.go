@@ -0,0 +1,50 @@
+package gloat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func noopMigrationFunc(context.Context, SQLExecer) error { return nil }
+
+func TestGoSourceCollect(t *testing.T) {
+	src := NewGoSource()
+	src.Register(20190101000000, "backfillUsers", noopMigrationFunc, noopMigrationFunc)
+	src.Register(20180101000000, "encrypt_tokens", noopMigrationFunc, nil)
+
+	migrations, err := src.Collect()
+	assert.Nil(t, err)
+
+	require.Len(t, migrations, 2)
+	assert.Equal(t, int64(20180101000000), migrations[0].Version)
+	assert.Equal(t, "20180101000000_encrypt_tokens", migrations[0].Path)
+	assert.False(t, migrations[0].Reversible())
+	assert.Equal(t, "20190101000000_backfill_users", migrations[1].Path)
+	assert.True(t, migrations[1].Reversible())
+	assert.True(t, migrations[1].Options.Transaction)
+}
+
+func TestGoSourceCollect_Copies(t *testing.T) {
+	src := NewGoSource()
+	src.Register(20190101000000, "backfill_users", noopMigrationFunc, nil)
+
+	migrations, err := src.Collect()
+	assert.Nil(t, err)
+	migrations[0].Version = 1
+
+	migrations, err = src.Collect()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(20190101000000), migrations[0].Version)
+}
+
+func TestGoSourceRegister_Duplicate(t *testing.T) {
+	src := NewGoSource()
+	src.Register(20190101000000, "backfill_users", noopMigrationFunc, nil)
+
+	assert.Panics(t, func() {
+		src.Register(20190101000000, "backfill_users_again", noopMigrationFunc, nil)
+	})
+}
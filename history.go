@@ -0,0 +1,279 @@
+package gloat
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HistoryAction is what happened to a migration in a history entry.
+type HistoryAction string
+
+const (
+	// ActionApply is a migration applied by the executor.
+	ActionApply HistoryAction = "apply"
+
+	// ActionRevert is a migration reverted by the executor.
+	ActionRevert HistoryAction = "revert"
+
+	// ActionBaseline is a migration recorded as applied by Baseline, without
+	// being executed.
+	ActionBaseline HistoryAction = "baseline"
+
+	// ActionMark is a migration recorded as applied by hand with MarkApplied.
+	ActionMark HistoryAction = "mark"
+
+	// ActionUnmark is a migration recorded as unapplied by hand with
+	// MarkUnapplied.
+	ActionUnmark HistoryAction = "unmark"
+)
+
+// HistoryEntry is a single run of a migration. Error is the error text of a
+// failed run. Repeatable migrations have a Name instead of a Version.
+type HistoryEntry struct {
+	Version   int64         `json:"version"`
+	Name      string        `json:"name,omitempty"`
+	Action    HistoryAction `json:"action"`
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration"`
+	Success   bool          `json:"success"`
+	Error     string        `json:"error,omitempty"`
+	Host      string        `json:"host,omitempty"`
+	AppliedBy string        `json:"applied_by,omitempty"`
+	Tag       string        `json:"tag,omitempty"`
+}
+
+// HistoryFilter selects history entries. A zero Version selects all versions
+// and zero times leave the range open.
+type HistoryFilter struct {
+	Version int64
+	Since   time.Time
+	Until   time.Time
+}
+
+// HistoryStore is an append-only log of every migration run. Unlike a Store,
+// it keeps the runs of reverted migrations.
+type HistoryStore interface {
+	Record(context.Context, *HistoryEntry) error
+	History(context.Context, HistoryFilter) ([]*HistoryEntry, error)
+}
+
+// DatabaseHistoryStore is a HistoryStore that keeps the history in a database
+// table, called schema_migrations_history unless configured with WithTable.
+// The table is automatically created if it does not exist.
+type DatabaseHistoryStore struct {
+	db SQLTransactor
+
+	mu      sync.Mutex
+	ensured bool
+
+	placeholder          func(int) string
+	createTableStatement string
+	insertStatement      string
+	selectStatement      string
+}
+
+// Record appends an entry to the history table.
+func (s *DatabaseHistoryStore) Record(ctx context.Context, entry *HistoryEntry) error {
+	if err := s.ensureHistoryTableExists(ctx); err != nil {
+		return err
+	}
+
+	_, err := sqlExecerContext(s.db).ExecContext(ctx, s.insertStatement,
+		entry.Version,
+		entry.Name,
+		string(entry.Action),
+		entry.StartedAt,
+		entry.Duration.Milliseconds(),
+		entry.Success,
+		entry.Error,
+		entry.Host,
+		entry.AppliedBy,
+		entry.Tag,
+	)
+	return err
+}
+
+// History returns the entries selected by the filter, oldest first.
+func (s *DatabaseHistoryStore) History(ctx context.Context, filter HistoryFilter) (entries []*HistoryEntry, err error) {
+	if err = s.ensureHistoryTableExists(ctx); err != nil {
+		return
+	}
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+
+	if filter.Version != 0 {
+		args = append(args, filter.Version)
+		conditions = append(conditions, "version = "+s.placeholder(len(args)))
+	}
+	if !filter.Since.IsZero() {
+		args = append(args, filter.Since.UTC())
+		conditions = append(conditions, "started_at >= "+s.placeholder(len(args)))
+	}
+	if !filter.Until.IsZero() {
+		args = append(args, filter.Until.UTC())
+		conditions = append(conditions, "started_at < "+s.placeholder(len(args)))
+	}
+
+	query := s.selectStatement
+	if len(conditions) != 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY started_at, id"
+
+	rows, err := sqlExecerContext(s.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			action                                   string
+			durationMs                               sql.NullInt64
+			name, errorMessage, host, appliedBy, tag sql.NullString
+		)
+
+		entry := &HistoryEntry{}
+		if err = rows.Scan(&entry.Version, &name, &action, &entry.StartedAt, &durationMs, &entry.Success, &errorMessage, &host, &appliedBy, &tag); err != nil {
+			return
+		}
+		entry.Name = name.String
+		entry.Action = HistoryAction(action)
+		entry.Duration = time.Duration(durationMs.Int64) * time.Millisecond
+		entry.Error = errorMessage.String
+		entry.Host = host.String
+		entry.AppliedBy = appliedBy.String
+		entry.Tag = tag.String
+
+		entries = append(entries, entry)
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+func (s *DatabaseHistoryStore) ensureHistoryTableExists(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ensured {
+		return nil
+	}
+
+	if _, err := sqlExecerContext(s.db).ExecContext(ctx, s.createTableStatement); err != nil {
+		return err
+	}
+
+	s.ensured = true
+	return nil
+}
+
+// record runs a migration action and appends its outcome to the history, if
+// there is a HistoryStore. The entry is recorded even if the context is
+// cancelled, so aborted runs are in the history too.
+func (c *Gloat) record(ctx context.Context, migration *Migration, action HistoryAction, run func() error) error {
+	if c.HistoryStore == nil {
+		return run()
+	}
+
+	start := time.Now()
+	err := run()
+
+	entry := &HistoryEntry{
+		Version:   migration.Version,
+		Name:      migration.Name,
+		Action:    action,
+		StartedAt: start.UTC(),
+		Duration:  time.Since(start),
+		Success:   err == nil,
+		Host:      currentHost(),
+		AppliedBy: currentUser(),
+		Tag:       c.Tag,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	if recordErr := c.HistoryStore.Record(context.Background(), entry); recordErr != nil && err == nil {
+		return fmt.Errorf("cannot record migration %d in the history: %w", migration.Version, recordErr)
+	}
+
+	return err
+}
+
+// historyColumns are the columns of the history table after the id.
+const historyColumns = `
+				version BIGINT NOT NULL,
+				name VARCHAR(255),
+				action VARCHAR(16) NOT NULL,
+				started_at %s NOT NULL,
+				duration_ms BIGINT,
+				success BOOLEAN NOT NULL,
+				error_message TEXT,
+				host VARCHAR(255),
+				applied_by VARCHAR(255),
+				tag VARCHAR(255)`
+
+const historyInsertColumns = `version, name, action, started_at, duration_ms, success, error_message, host, applied_by, tag`
+
+// NewPostgreSQLHistoryStore creates a HistoryStore for PostgreSQL.
+func NewPostgreSQLHistoryStore(db SQLTransactor, options ...TableOption) HistoryStore {
+	table := newTableOptions(options).qualify(quoteDoubleQuotes, "_history")
+
+	return &DatabaseHistoryStore{
+		db:          db,
+		placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+		createTableStatement: fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s (
+				id BIGSERIAL PRIMARY KEY NOT NULL,`+historyColumns+`
+			)`, table, "timestamp without time zone"),
+		insertStatement: fmt.Sprintf(`
+			INSERT INTO %s (%s)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`, table, historyInsertColumns),
+		selectStatement: fmt.Sprintf(`SELECT %s FROM %s`, historyInsertColumns, table),
+	}
+}
+
+// NewMySQLHistoryStore creates a HistoryStore for MySQL.
+func NewMySQLHistoryStore(db SQLTransactor, options ...TableOption) HistoryStore {
+	table := newTableOptions(options).qualify(quoteBackticks, "_history")
+
+	return &DatabaseHistoryStore{
+		db:          db,
+		placeholder: func(int) string { return "?" },
+		createTableStatement: fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s (
+				id BIGINT PRIMARY KEY NOT NULL AUTO_INCREMENT,`+historyColumns+`
+			)`, table, "DATETIME(6)"),
+		insertStatement: fmt.Sprintf(`
+			INSERT INTO %s (%s)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, table, historyInsertColumns),
+		selectStatement: fmt.Sprintf(`SELECT %s FROM %s`, historyInsertColumns, table),
+	}
+}
+
+// NewSQLite3HistoryStore creates a HistoryStore for SQLite3.
+func NewSQLite3HistoryStore(db SQLTransactor, options ...TableOption) HistoryStore {
+	table := newTableOptions(options).qualify(quoteDoubleQuotes, "_history")
+
+	return &DatabaseHistoryStore{
+		db:          db,
+		placeholder: func(int) string { return "?" },
+		createTableStatement: fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,`+historyColumns+`
+			)`, table, "DATETIME"),
+		insertStatement: fmt.Sprintf(`
+			INSERT INTO %s (%s)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, table, historyInsertColumns),
+		selectStatement: fmt.Sprintf(`SELECT %s FROM %s`, historyInsertColumns, table),
+	}
+}
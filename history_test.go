@@ -0,0 +1,117 @@
+package gloat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testingHistoryStore struct{ entries []*HistoryEntry }
+
+func (s *testingHistoryStore) Record(_ context.Context, entry *HistoryEntry) error {
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *testingHistoryStore) History(context.Context, HistoryFilter) ([]*HistoryEntry, error) {
+	return s.entries, nil
+}
+
+func historyStoreFactory(driver string, db *sql.DB) (HistoryStore, error) {
+	switch driver {
+	case "postgres", "postgresql":
+		return NewPostgreSQLHistoryStore(db), nil
+	case "mysql":
+		return NewMySQLHistoryStore(db), nil
+	case "sqlite", "sqlite3":
+		return NewSQLite3HistoryStore(db), nil
+	}
+
+	return nil, errors.New("unsupported database driver " + driver)
+}
+
+func TestDatabaseHistoryStore(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := historyStoreFactory(dbDriver, db)
+	require.Nil(t, err)
+
+	day := time.Date(2018, 9, 5, 12, 0, 0, 0, time.UTC)
+
+	cleanState(func() {
+		entries := []*HistoryEntry{
+			{Version: 1, Action: ActionApply, StartedAt: day, Duration: time.Second, Success: true, Host: "deploy-1", AppliedBy: "deployer", Tag: "release-1"},
+			{Version: 1, Action: ActionRevert, StartedAt: day.Add(time.Hour), Success: false, Error: "boom"},
+			{Version: 2, Action: ActionApply, StartedAt: day.Add(48 * time.Hour), Success: true},
+		}
+		for _, entry := range entries {
+			require.Nil(t, store.Record(ctx, entry))
+		}
+
+		history, err := store.History(ctx, HistoryFilter{})
+		require.Nil(t, err)
+		require.Len(t, history, 3)
+		assert.Equal(t, entries[0].Version, history[0].Version)
+		assert.Equal(t, ActionApply, history[0].Action)
+		assert.True(t, history[0].StartedAt.Equal(day))
+		assert.Equal(t, time.Second, history[0].Duration)
+		assert.True(t, history[0].Success)
+		assert.Equal(t, "deploy-1", history[0].Host)
+		assert.Equal(t, "deployer", history[0].AppliedBy)
+		assert.Equal(t, "release-1", history[0].Tag)
+		assert.False(t, history[1].Success)
+		assert.Equal(t, "boom", history[1].Error)
+
+		history, err = store.History(ctx, HistoryFilter{Version: 1})
+		require.Nil(t, err)
+		assert.Len(t, history, 2)
+
+		history, err = store.History(ctx, HistoryFilter{Since: day.Add(time.Minute), Until: day.Add(24 * time.Hour)})
+		require.Nil(t, err)
+		require.Len(t, history, 1)
+		assert.Equal(t, ActionRevert, history[0].Action)
+	})
+}
+
+func TestHistory(t *testing.T) {
+	history := &testingHistoryStore{}
+	expectedErr := errors.New("broken")
+
+	gl.Store = &testingStore{}
+	gl.HistoryStore = history
+	gl.Executor = &stubbedExecutor{
+		up: func(m *Migration, _ Store) error {
+			if m.Version == 2 {
+				return expectedErr
+			}
+			return nil
+		},
+	}
+	defer func() { gl.HistoryStore = nil }()
+
+	assert.Nil(t, gl.Apply(&Migration{Version: 1}))
+	assert.Nil(t, gl.Revert(&Migration{Version: 1}))
+	assert.Equal(t, expectedErr, gl.Apply(&Migration{Version: 2}))
+
+	entries, err := gl.History(HistoryFilter{})
+	assert.Nil(t, err)
+
+	require.Len(t, entries, 3)
+	assert.Equal(t, ActionApply, entries[0].Action)
+	assert.True(t, entries[0].Success)
+	assert.Equal(t, ActionRevert, entries[1].Action)
+	assert.Equal(t, int64(2), entries[2].Version)
+	assert.False(t, entries[2].Success)
+	assert.Equal(t, "broken", entries[2].Error)
+}
+
+func TestHistory_Nil(t *testing.T) {
+	entries, err := gl.History(HistoryFilter{})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 0)
+}
@@ -0,0 +1,115 @@
+package gloat
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Hook is notified around every migration Gloat applies or reverts. An error
+// returned from BeforeApply or BeforeRevert vetoes the migration, which is
+// then not run at all.
+type Hook interface {
+	BeforeApply(context.Context, *Migration) error
+	AfterApply(context.Context, *Migration, time.Duration)
+	BeforeRevert(context.Context, *Migration) error
+	AfterRevert(context.Context, *Migration, time.Duration)
+	OnError(context.Context, *Migration, Direction, time.Duration, error)
+}
+
+// HookFuncs is a Hook built out of functions. Nil functions are skipped.
+type HookFuncs struct {
+	BeforeApplyFunc  func(context.Context, *Migration) error
+	AfterApplyFunc   func(context.Context, *Migration, time.Duration)
+	BeforeRevertFunc func(context.Context, *Migration) error
+	AfterRevertFunc  func(context.Context, *Migration, time.Duration)
+	OnErrorFunc      func(context.Context, *Migration, Direction, time.Duration, error)
+}
+
+// BeforeApply implements the Hook interface.
+func (h HookFuncs) BeforeApply(ctx context.Context, migration *Migration) error {
+	if h.BeforeApplyFunc == nil {
+		return nil
+	}
+
+	return h.BeforeApplyFunc(ctx, migration)
+}
+
+// AfterApply implements the Hook interface.
+func (h HookFuncs) AfterApply(ctx context.Context, migration *Migration, duration time.Duration) {
+	if h.AfterApplyFunc != nil {
+		h.AfterApplyFunc(ctx, migration, duration)
+	}
+}
+
+// BeforeRevert implements the Hook interface.
+func (h HookFuncs) BeforeRevert(ctx context.Context, migration *Migration) error {
+	if h.BeforeRevertFunc == nil {
+		return nil
+	}
+
+	return h.BeforeRevertFunc(ctx, migration)
+}
+
+// AfterRevert implements the Hook interface.
+func (h HookFuncs) AfterRevert(ctx context.Context, migration *Migration, duration time.Duration) {
+	if h.AfterRevertFunc != nil {
+		h.AfterRevertFunc(ctx, migration, duration)
+	}
+}
+
+// OnError implements the Hook interface.
+func (h HookFuncs) OnError(ctx context.Context, migration *Migration, direction Direction, duration time.Duration, err error) {
+	if h.OnErrorFunc != nil {
+		h.OnErrorFunc(ctx, migration, direction, duration, err)
+	}
+}
+
+// VetoError is the error returned when a Before hook vetoes a migration. Err
+// is the error the hook returned.
+type VetoError struct {
+	Version   int64
+	Direction Direction
+	Err       error
+}
+
+// Error implements the error interface.
+func (err VetoError) Error() string {
+	return fmt.Sprintf("migration %d vetoed going %s: %v", err.Version, err.Direction, err.Err)
+}
+
+// Unwrap returns the error the hook returned.
+func (err VetoError) Unwrap() error {
+	return err.Err
+}
+
+// runHooks runs a migration in a direction, notifying the hooks around it.
+func (c *Gloat) runHooks(ctx context.Context, migration *Migration, direction Direction, run func() error) error {
+	for _, hook := range c.Hooks {
+		before := hook.BeforeApply
+		if direction == Down {
+			before = hook.BeforeRevert
+		}
+
+		if err := before(ctx, migration); err != nil {
+			return VetoError{Version: migration.Version, Direction: direction, Err: err}
+		}
+	}
+
+	start := time.Now()
+	err := run()
+	duration := time.Since(start)
+
+	for _, hook := range c.Hooks {
+		switch {
+		case err != nil:
+			hook.OnError(ctx, migration, direction, duration, err)
+		case direction == Down:
+			hook.AfterRevert(ctx, migration, duration)
+		default:
+			hook.AfterApply(ctx, migration, duration)
+		}
+	}
+
+	return err
+}
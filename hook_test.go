@@ -0,0 +1,105 @@
+package gloat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func recordingHook(events *[]string) Hook {
+	return HookFuncs{
+		BeforeApplyFunc: func(_ context.Context, m *Migration) error {
+			*events = append(*events, fmt.Sprintf("before apply %d", m.Version))
+			return nil
+		},
+		AfterApplyFunc: func(_ context.Context, m *Migration, _ time.Duration) {
+			*events = append(*events, fmt.Sprintf("after apply %d", m.Version))
+		},
+		BeforeRevertFunc: func(_ context.Context, m *Migration) error {
+			*events = append(*events, fmt.Sprintf("before revert %d", m.Version))
+			return nil
+		},
+		AfterRevertFunc: func(_ context.Context, m *Migration, _ time.Duration) {
+			*events = append(*events, fmt.Sprintf("after revert %d", m.Version))
+		},
+		OnErrorFunc: func(_ context.Context, m *Migration, direction Direction, _ time.Duration, err error) {
+			*events = append(*events, fmt.Sprintf("error %s %d: %v", direction, m.Version, err))
+		},
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var events []string
+
+	gl.Store = &testingStore{}
+	gl.Executor = &stubbedExecutor{
+		up: func(m *Migration, _ Store) error {
+			events = append(events, fmt.Sprintf("up %d", m.Version))
+			return nil
+		},
+	}
+	gl.Hooks = []Hook{recordingHook(&events)}
+	defer func() { gl.Hooks = nil }()
+
+	migration := &Migration{Version: 1}
+
+	assert.Nil(t, gl.Apply(migration))
+	assert.Nil(t, gl.Revert(migration))
+
+	assert.Equal(t, []string{
+		"before apply 1",
+		"up 1",
+		"after apply 1",
+		"before revert 1",
+		"after revert 1",
+	}, events)
+}
+
+func TestHooks_OnError(t *testing.T) {
+	var events []string
+
+	expectedErr := errors.New("broken")
+
+	gl.Store = &testingStore{}
+	gl.Executor = &stubbedExecutor{
+		up: func(*Migration, Store) error { return expectedErr },
+	}
+	gl.Hooks = []Hook{recordingHook(&events)}
+	defer func() { gl.Hooks = nil }()
+
+	err := gl.Apply(&Migration{Version: 1})
+	assert.Equal(t, expectedErr, err)
+
+	assert.Equal(t, []string{"before apply 1", "error up 1: broken"}, events)
+}
+
+func TestHooks_Veto(t *testing.T) {
+	var events []string
+
+	expectedErr := errors.New("not during business hours")
+
+	gl.Store = &testingStore{}
+	gl.Executor = &stubbedExecutor{
+		up: func(m *Migration, _ Store) error {
+			events = append(events, fmt.Sprintf("up %d", m.Version))
+			return nil
+		},
+	}
+	gl.Hooks = []Hook{
+		HookFuncs{
+			BeforeApplyFunc: func(context.Context, *Migration) error { return expectedErr },
+		},
+		recordingHook(&events),
+	}
+	defer func() { gl.Hooks = nil }()
+
+	err := gl.Apply(&Migration{Version: 1})
+	assert.Equal(t, VetoError{Version: 1, Direction: Up, Err: expectedErr}, err)
+	assert.True(t, errors.Is(err, expectedErr))
+
+	assert.Len(t, events, 0)
+}
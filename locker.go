@@ -0,0 +1,323 @@
+package gloat
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+var (
+	// DefaultLockTimeout is the time a Locker waits for the migration lock
+	// before giving up, if no other timeout is given.
+	DefaultLockTimeout = time.Minute
+
+	lockPollInterval = 100 * time.Millisecond
+)
+
+// LockTimeoutError is the error returned when the migration lock cannot be
+// acquired in the given timeout. Usually means that another process is
+// applying migrations at the same time.
+type LockTimeoutError struct {
+	Timeout time.Duration
+
+	// Table is the lock table for lockers backed by one. A lock left by a
+	// crashed process stays there until removed by hand.
+	Table string
+}
+
+// Error implements the error interface.
+func (err LockTimeoutError) Error() string {
+	if err.Table != "" {
+		return fmt.Sprintf("cannot acquire migration lock in %s; if no other process is migrating, the lock is stale and has to be removed from %s by hand", err.Timeout, err.Table)
+	}
+
+	return fmt.Sprintf("cannot acquire migration lock in %s", err.Timeout)
+}
+
+// Locker is an interface representing a lock held while migrations are
+// collected and applied, so concurrent runs cannot apply the same migration
+// twice.
+type Locker interface {
+	Lock() error
+	Unlock() error
+}
+
+// ContextLocker is a Locker that stops waiting for the lock once a context is
+// done.
+type ContextLocker interface {
+	Locker
+
+	LockContext(context.Context) error
+}
+
+// LockContext acquires the lock of a locker with a context. If the locker is
+// not a ContextLocker, the context is only checked before locking.
+func LockContext(ctx context.Context, locker Locker) error {
+	if locker, ok := locker.(ContextLocker); ok {
+		return locker.LockContext(ctx)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return locker.Lock()
+}
+
+// AdvisoryLocker is a Locker that uses the database builtin advisory locks.
+// The locks are bound to a database session, so the locker holds a dedicated
+// connection while locked.
+type AdvisoryLocker struct {
+	db      *sql.DB
+	conn    *sql.Conn
+	key     interface{}
+	timeout time.Duration
+
+	lockStatement   string
+	unlockStatement string
+}
+
+// Lock acquires the advisory lock, waiting up to the locker timeout for it.
+// Locking an already held lock is a no-op.
+func (l *AdvisoryLocker) Lock() error {
+	return l.LockContext(context.Background())
+}
+
+// LockContext is like Lock, but stops waiting once the context is done.
+func (l *AdvisoryLocker) LockContext(ctx context.Context) error {
+	if l.conn != nil {
+		return nil
+	}
+
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = pollLock(ctx, l.timeout, func() (bool, error) {
+		var locked sql.NullBool
+		if err := conn.QueryRowContext(ctx, l.lockStatement, l.key).Scan(&locked); err != nil {
+			return false, err
+		}
+
+		return locked.Valid && locked.Bool, nil
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	l.conn = conn
+	return nil
+}
+
+// Unlock releases the advisory lock and the connection it is bound to.
+func (l *AdvisoryLocker) Unlock() error {
+	if l.conn == nil {
+		return nil
+	}
+
+	defer func() { l.conn = nil }()
+	defer l.conn.Close()
+
+	_, err := l.conn.ExecContext(context.Background(), l.unlockStatement, l.key)
+	return err
+}
+
+// TableLocker is a Locker for databases without advisory locks. It holds the
+// lock by inserting a row in a lock table, schema_migrations_lock by default.
+// The table is automatically created if it does not exist.
+//
+// If a process dies while holding the lock, the row has to be removed by hand.
+type TableLocker struct {
+	db      SQLTransactor
+	owner   string
+	table   string
+	timeout time.Duration
+
+	createTableStatement string
+	lockStatement        string
+	unlockStatement      string
+}
+
+// Lock acquires the table lock, waiting up to the locker timeout for it.
+// Locking an already held lock is a no-op.
+func (l *TableLocker) Lock() error {
+	return l.LockContext(context.Background())
+}
+
+// LockContext is like Lock, but stops waiting once the context is done.
+func (l *TableLocker) LockContext(ctx context.Context) error {
+	if l.owner != "" {
+		return nil
+	}
+
+	execer := sqlExecerContext(l.db)
+
+	if _, err := execer.ExecContext(ctx, l.createTableStatement); err != nil {
+		return err
+	}
+
+	owner, err := randomLockOwner()
+	if err != nil {
+		return err
+	}
+
+	err = pollLock(ctx, l.timeout, func() (bool, error) {
+		result, err := execer.ExecContext(ctx, l.lockStatement, owner, time.Now().UTC())
+		if err != nil {
+			return false, err
+		}
+
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return false, err
+		}
+
+		return inserted == 1, nil
+	})
+	if timeoutErr, ok := err.(LockTimeoutError); ok {
+		timeoutErr.Table = l.table
+		return timeoutErr
+	}
+	if err != nil {
+		return err
+	}
+
+	l.owner = owner
+	return nil
+}
+
+// Unlock releases the table lock. Only the lock held by this locker is
+// released, unlocking a lock that is not held is a no-op.
+func (l *TableLocker) Unlock() error {
+	if l.owner == "" {
+		return nil
+	}
+
+	defer func() { l.owner = "" }()
+
+	_, err := l.db.Exec(l.unlockStatement, l.owner)
+	return err
+}
+
+func randomLockOwner() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func pollLock(ctx context.Context, timeout time.Duration, try func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+
+	for {
+		locked, err := try()
+		if err != nil {
+			return err
+		}
+
+		if locked {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return LockTimeoutError{Timeout: timeout}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func advisoryLockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// NewPostgreSQLLocker creates a Locker for PostgreSQL using
+// pg_advisory_lock. A zero timeout means DefaultLockTimeout. The lock is
+// specific to the migrations table the options point to.
+func NewPostgreSQLLocker(db *sql.DB, timeout time.Duration, options ...TableOption) Locker {
+	if timeout == 0 {
+		timeout = DefaultLockTimeout
+	}
+
+	return &AdvisoryLocker{
+		db:              db,
+		key:             advisoryLockKey(newTableOptions(options).name("")),
+		timeout:         timeout,
+		lockStatement:   `SELECT pg_try_advisory_lock($1)`,
+		unlockStatement: `SELECT pg_advisory_unlock($1)`,
+	}
+}
+
+// NewMySQLLocker creates a Locker for MySQL using GET_LOCK. A zero timeout
+// means DefaultLockTimeout. The lock is specific to the migrations table the
+// options point to. GET_LOCK names are shared by the whole server, so without
+// a schema the table is qualified with the database of the connection.
+func NewMySQLLocker(db *sql.DB, timeout time.Duration, options ...TableOption) Locker {
+	if timeout == 0 {
+		timeout = DefaultLockTimeout
+	}
+
+	lockStatement := `SELECT GET_LOCK(CONCAT(COALESCE(DATABASE(), ''), '.', ?), 0)`
+	unlockStatement := `SELECT RELEASE_LOCK(CONCAT(COALESCE(DATABASE(), ''), '.', ?))`
+
+	o := newTableOptions(options)
+	if o.schema != "" {
+		lockStatement = `SELECT GET_LOCK(?, 0)`
+		unlockStatement = `SELECT RELEASE_LOCK(?)`
+	}
+
+	return &AdvisoryLocker{
+		db:              db,
+		key:             o.name(""),
+		timeout:         timeout,
+		lockStatement:   lockStatement,
+		unlockStatement: unlockStatement,
+	}
+}
+
+// NewSQLite3Locker creates a Locker for SQLite3 backed by a lock table. A zero
+// timeout means DefaultLockTimeout. The lock table is named after the
+// migrations table the options point to, with a _lock suffix.
+func NewSQLite3Locker(db SQLTransactor, timeout time.Duration, options ...TableOption) Locker {
+	if timeout == 0 {
+		timeout = DefaultLockTimeout
+	}
+
+	o := newTableOptions(options)
+	table := o.qualify(quoteDoubleQuotes, "_lock")
+
+	return &TableLocker{
+		db:      db,
+		table:   o.name("_lock"),
+		timeout: timeout,
+		createTableStatement: fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s (
+				id INTEGER PRIMARY KEY NOT NULL,
+				owner VARCHAR(32) NOT NULL,
+				locked_at DATETIME
+			)`, table),
+		lockStatement: fmt.Sprintf(`
+			INSERT OR IGNORE INTO %s (id, owner, locked_at)
+			VALUES (1, ?, ?)`, table),
+		unlockStatement: fmt.Sprintf(`
+			DELETE FROM %s
+			WHERE id=1 AND owner=?`, table),
+	}
+}
@@ -0,0 +1,193 @@
+package gloat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func lockerFactory(driver string, db *sql.DB, timeout time.Duration, options ...TableOption) (Locker, error) {
+	switch driver {
+	case "postgres", "postgresql":
+		return NewPostgreSQLLocker(db, timeout, options...), nil
+	case "mysql":
+		return NewMySQLLocker(db, timeout, options...), nil
+	case "sqlite", "sqlite3":
+		return NewSQLite3Locker(db, timeout, options...), nil
+	}
+
+	return nil, errors.New("unsupported database driver " + driver)
+}
+
+func TestLocker_Lock(t *testing.T) {
+	locker, err := lockerFactory(dbDriver, db, time.Second)
+	require.Nil(t, err)
+
+	err = locker.Lock()
+	require.Nil(t, err)
+
+	err = locker.Unlock()
+	assert.Nil(t, err)
+}
+
+func TestLocker_Lock_Timeout(t *testing.T) {
+	locker, err := lockerFactory(dbDriver, db, time.Second)
+	require.Nil(t, err)
+
+	other, err := lockerFactory(dbDriver, db, 200*time.Millisecond)
+	require.Nil(t, err)
+
+	err = locker.Lock()
+	require.Nil(t, err)
+	defer locker.Unlock()
+
+	err = other.Lock()
+	require.IsType(t, LockTimeoutError{}, err)
+	assert.Equal(t, 200*time.Millisecond, err.(LockTimeoutError).Timeout)
+}
+
+func TestLocker_Lock_OtherTable(t *testing.T) {
+	locker, err := lockerFactory(dbDriver, db, time.Second)
+	require.Nil(t, err)
+
+	other, err := lockerFactory(dbDriver, db, 200*time.Millisecond, WithTable("billing_migrations"))
+	require.Nil(t, err)
+
+	err = locker.Lock()
+	require.Nil(t, err)
+	defer locker.Unlock()
+
+	err = other.Lock()
+	require.Nil(t, err)
+	assert.Nil(t, other.Unlock())
+}
+
+func TestNewSQLite3Locker_WithTable(t *testing.T) {
+	locker := NewSQLite3Locker(nil, 0, WithSchema("audit"), WithTable("billing_migrations")).(*TableLocker)
+
+	assert.Equal(t, "audit.billing_migrations_lock", locker.table)
+	assert.Contains(t, locker.lockStatement, `"audit"."billing_migrations_lock"`)
+}
+
+func TestLocker_Lock_Twice(t *testing.T) {
+	locker, err := lockerFactory(dbDriver, db, time.Second)
+	require.Nil(t, err)
+
+	err = locker.Lock()
+	require.Nil(t, err)
+
+	err = locker.Lock()
+	assert.Nil(t, err)
+
+	err = locker.Unlock()
+	assert.Nil(t, err)
+
+	other, err := lockerFactory(dbDriver, db, time.Second)
+	require.Nil(t, err)
+
+	err = other.Lock()
+	assert.Nil(t, err)
+
+	err = other.Unlock()
+	assert.Nil(t, err)
+}
+
+func TestLocker_Unlock_NotHeld(t *testing.T) {
+	locker, err := lockerFactory(dbDriver, db, time.Second)
+	require.Nil(t, err)
+
+	other, err := lockerFactory(dbDriver, db, 200*time.Millisecond)
+	require.Nil(t, err)
+
+	err = locker.Lock()
+	require.Nil(t, err)
+	defer locker.Unlock()
+
+	err = other.Unlock()
+	assert.Nil(t, err)
+
+	err = other.Lock()
+	assert.IsType(t, LockTimeoutError{}, err)
+}
+
+func TestLockTimeoutError_Table(t *testing.T) {
+	err := LockTimeoutError{Timeout: time.Second, Table: "schema_migrations_lock"}
+
+	assert.Contains(t, err.Error(), "schema_migrations_lock")
+}
+
+func TestLocker_Lock_AfterUnlock(t *testing.T) {
+	locker, err := lockerFactory(dbDriver, db, time.Second)
+	require.Nil(t, err)
+
+	other, err := lockerFactory(dbDriver, db, time.Second)
+	require.Nil(t, err)
+
+	err = locker.Lock()
+	require.Nil(t, err)
+
+	err = locker.Unlock()
+	require.Nil(t, err)
+
+	err = other.Lock()
+	assert.Nil(t, err)
+
+	err = other.Unlock()
+	assert.Nil(t, err)
+}
+
+func TestLocker_LockContext_Cancelled(t *testing.T) {
+	locker, err := lockerFactory(dbDriver, db, time.Second)
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = LockContext(ctx, locker)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestLocker_LockContext_CancelledWhileWaiting(t *testing.T) {
+	locker, err := lockerFactory(dbDriver, db, time.Second)
+	require.Nil(t, err)
+
+	other, err := lockerFactory(dbDriver, db, time.Minute)
+	require.Nil(t, err)
+
+	err = locker.Lock()
+	require.Nil(t, err)
+	defer locker.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	err = LockContext(ctx, other)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestLockContext_Fallback(t *testing.T) {
+	locker := &testingLocker{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := LockContext(ctx, locker)
+	assert.Equal(t, context.Canceled, err)
+	assert.False(t, locker.locked)
+}
+
+func TestNewMySQLLocker_Key(t *testing.T) {
+	locker := NewMySQLLocker(nil, 0).(*AdvisoryLocker)
+	assert.Equal(t, "schema_migrations", locker.key)
+	assert.Contains(t, locker.lockStatement, "DATABASE()")
+	assert.Contains(t, locker.unlockStatement, "DATABASE()")
+
+	locker = NewMySQLLocker(nil, 0, WithSchema("billing")).(*AdvisoryLocker)
+	assert.Equal(t, "billing.schema_migrations", locker.key)
+	assert.Equal(t, `SELECT GET_LOCK(?, 0)`, locker.lockStatement)
+}
@@ -1,6 +1,10 @@
 package gloat
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"path/filepath"
@@ -14,26 +18,65 @@ import (
 var (
 	ErrNotFound      = errors.New("version not found")
 	nameNormalizerRe = regexp.MustCompile(`([a-z])([A-Z])`)
+	migrationFileRe  = regexp.MustCompile(`^[0-9]+_.+\.sql$`)
 	versionFormat    = "20060102150405"
 )
 
+var (
+	ErrNotEmpty              = errors.New("store already has applied migrations")
+	ErrAlreadyApplied        = errors.New("migration already applied")
+	ErrNotApplied            = errors.New("migration not applied")
+	ErrRepeatableUnsupported = errors.New("store does not record repeatable migrations")
+)
+
+const (
+	repeatablePrefix   = "R_"
+	migrationDirective = "-- +gloat"
+)
+
 // Migration holds all the relevant information for a migration. The content of
 // the UP side, the DOWN side, a path and version. The version is used to
 // determine the order of which the migrations would be executed. The path is
-// the name in a store.
+// the name in a store. The checksum is a hash of the UP and DOWN content, used
+// to notice changes to already applied migrations.
+//
+// Go migrations have UpFunc and DownFunc instead of UP and DOWN content.
+//
+// Repeatable migrations have a Name instead of a version. They are applied
+// again whenever their checksum changes, see RepeatableSource.
+//
+// Applied migrations also carry how long they took to run, the host and OS
+// user that ran them, the gloat version and the deploy tag of the run.
 type Migration struct {
 	UpSQL     []byte
 	DownSQL   []byte
+	UpFunc    MigrationFunc
+	DownFunc  MigrationFunc
 	Path      string
 	Version   int64
 	Options   MigrationOptions
 	AppliedAt time.Time
+	Checksum  string
+
+	Name       string
+	Repeatable bool
+
+	Duration     time.Duration
+	Host         string
+	AppliedBy    string
+	GloatVersion string
+	Tag          string
 }
 
+// MigrationFunc is a migration direction written in Go. It is run with the
+// same transaction handling as the SQL migrations.
+type MigrationFunc func(context.Context, SQLExecer) error
+
 // Reversible returns true if the migration DownSQL content is present. E.g. if
-// both of the directions are present in the migration folder.
+// both of the directions are present in the migration folder. Go migrations
+// are reversible if they have a DownFunc.
 func (m *Migration) Reversible() bool {
-	return len(m.DownSQL) != 0
+	return len(m.DownSQL) != 0 || m.DownFunc != nil
 }
 
 // Persistable is any migration with non blank Path.
@@ -90,9 +133,137 @@ func MigrationFromBytes(path string, read func(string) ([]byte, error)) (*Migrat
 		Version:   version,
 		Options:   options,
 		AppliedAt: time.Time{},
+		Checksum:  Checksum(upSQL, downSQL),
+	}, nil
+}
+
+// MigrationFromFile builds a Migration struct from a single SQL file, like
+// 20170329154959_add_users.sql, and a function reading it. The file has its
+// UP content after a "-- +gloat Up" line and its DOWN content after a
+// "-- +gloat Down" line. A "-- +gloat NoTransaction" line runs it outside of
+// a transaction.
+func MigrationFromFile(path string, read func(string) ([]byte, error)) (*Migration, error) {
+	version, err := versionFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	upSQL, downSQL, options, err := parseMigrationFile(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &Migration{
+		UpSQL:    upSQL,
+		DownSQL:  downSQL,
+		Path:     path,
+		Version:  version,
+		Options:  options,
+		Checksum: Checksum(upSQL, downSQL),
+	}, nil
+}
+
+// IsMigrationFile reports whether a file holds a single file migration, which
+// is when it is named like 20170329154959_add_users.sql. Other SQL files, like
+// a schema dump, are not migrations.
+func IsMigrationFile(path string) bool {
+	return migrationFileRe.MatchString(filepath.Base(path))
+}
+
+// parseMigrationFile splits the content of a single file migration into its
+// UP and DOWN content along the +gloat directives.
+func parseMigrationFile(content []byte) (upSQL, downSQL []byte, options MigrationOptions, err error) {
+	options = DefaultMigrationOptions()
+
+	var (
+		section *[]byte
+		seenUp  bool
+	)
+
+	for i, line := range strings.SplitAfter(string(content), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, migrationDirective) {
+			switch directive := strings.TrimSpace(strings.TrimPrefix(trimmed, migrationDirective)); {
+			case strings.EqualFold(directive, "Up"):
+				section, seenUp = &upSQL, true
+			case strings.EqualFold(directive, "Down"):
+				section = &downSQL
+			case strings.EqualFold(directive, "NoTransaction"):
+				options.Transaction = false
+			default:
+				return nil, nil, options, fmt.Errorf("unknown directive %q at line %d", trimmed, i+1)
+			}
+			continue
+		}
+
+		if section == nil {
+			if trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+				return nil, nil, options, fmt.Errorf("statement before the %s Up directive at line %d", migrationDirective, i+1)
+			}
+			continue
+		}
+
+		*section = append(*section, line...)
+	}
+
+	if !seenUp {
+		return nil, nil, options, fmt.Errorf("missing the %s Up directive", migrationDirective)
+	}
+
+	return bytes.TrimSpace(upSQL), bytes.TrimSpace(downSQL), options, nil
+}
+
+// RepeatableMigrationFromBytes builds a repeatable Migration from a path and
+// a function, like MigrationFromBytes. The folder is named R_<name> and has no
+// version. Only the up.sql content is read, as repeatable migrations are never
+// reverted.
+func RepeatableMigrationFromBytes(path string, read func(string) ([]byte, error)) (*Migration, error) {
+	upSQL, err := read(filepath.Join(path, "up.sql"))
+	if err != nil {
+		return nil, err
+	}
+
+	optionsJSON, err := read(filepath.Join(path, "options.json"))
+	if err != nil {
+		optionsJSON = nil
+	}
+
+	options, err := parseMigrationOptions(optionsJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migration{
+		UpSQL:      upSQL,
+		Path:       path,
+		Name:       strings.TrimPrefix(filepath.Base(path), repeatablePrefix),
+		Repeatable: true,
+		Options:    options,
+		Checksum:   Checksum(upSQL, nil),
 	}, nil
 }
 
+// IsRepeatablePath reports whether a migration folder holds a repeatable
+// migration, which is when its name starts with R_.
+func IsRepeatablePath(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), repeatablePrefix)
+}
+
+// Checksum computes the checksum of a migration UP and DOWN content.
+func Checksum(upSQL, downSQL []byte) string {
+	h := sha256.New()
+	h.Write(upSQL)
+	h.Write([]byte{0})
+	h.Write(downSQL)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 func generateMigrationPath(version int64, str string) string {
 	name := strings.ToLower(nameNormalizerRe.ReplaceAllString(str, "${1}_${2}"))
 	return fmt.Sprintf("%d_%s", version, name)
@@ -192,6 +363,11 @@ func (m Migrations) Sort() { sort.Sort(m) }
 // ReverseSort is a convenience sorting method.
 func (m Migrations) ReverseSort() { sort.Sort(sort.Reverse(m)) }
 
+// SortByName sorts repeatable migrations by their name.
+func (m Migrations) SortByName() {
+	sort.Slice(m, func(i, j int) bool { return m[i].Name < m[j].Name })
+}
+
 // Current returns the latest applied migration. Can be nil, if the migrations
 // are empty.
 func (m Migrations) Current() *Migration {
@@ -204,10 +380,26 @@ func (m Migrations) Current() *Migration {
 	return m[len(m)-1]
 }
 
+// Find returns the migration with the given version or nil, if there is none.
+func (m Migrations) Find(version int64) *Migration {
+	for _, migration := range m {
+		if migration.Version == version {
+			return migration
+		}
+	}
+
+	return nil
+}
+
 // AppliedAfter selects the applied migrations from a Store after a given version.
 func AppliedAfter(store Source, source Source, version int64) (Migrations, error) {
+	return AppliedAfterContext(context.Background(), store, source, version)
+}
+
+// AppliedAfterContext is like AppliedAfter, but with a context.
+func AppliedAfterContext(ctx context.Context, store Source, source Source, version int64) (Migrations, error) {
 	var appliedAfter Migrations
-	appliedMigrations, err := store.Collect()
+	appliedMigrations, err := CollectContext(ctx, store)
 	if err != nil {
 		return nil, err
 	}
@@ -227,7 +419,7 @@ func AppliedAfter(store Source, source Source, version int64) (Migrations, error
 
 	appliedAfter.ReverseSort()
 
-	availableMigrations, err := source.Collect()
+	availableMigrations, err := CollectContext(ctx, source)
 	if err != nil {
 		return nil, err
 	}
@@ -237,20 +429,78 @@ func AppliedAfter(store Source, source Source, version int64) (Migrations, error
 	return intersect, nil
 }
 
+// ChangedMigrations selects the applied migrations from a Store, whose content
+// in the Source changed since they were applied. Migrations recorded without a
+// checksum and squashed migrations are skipped, as there is nothing to compare
+// them to.
+func ChangedMigrations(store, source Source) (Migrations, error) {
+	return ChangedMigrationsContext(context.Background(), store, source)
+}
+
+// ChangedMigrationsContext is like ChangedMigrations, but with a context.
+func ChangedMigrationsContext(ctx context.Context, store, source Source) (Migrations, error) {
+	appliedMigrations, err := CollectContext(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	availableMigrations, err := CollectContext(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := make(map[int64]string)
+	for _, migrationMetadata := range appliedMigrations {
+		checksums[migrationMetadata.Version] = migrationMetadata.Checksum
+	}
+
+	var changedMigrations Migrations
+	for _, fullMigration := range availableMigrations {
+		checksum, applied := checksums[fullMigration.Version]
+		if !applied || checksum == "" || fullMigration.Checksum == "" {
+			continue
+		}
+
+		// A squashed migration replaced the one applied with its version.
+		if len(fullMigration.Options.Squashes) != 0 {
+			continue
+		}
+
+		if checksum != fullMigration.Checksum {
+			changedMigrations = append(changedMigrations, fullMigration)
+		}
+	}
+
+	changedMigrations.Sort()
+
+	return changedMigrations, nil
+}
+
 // UnappliedMigrations selects the unapplied migrations from a Source. For a
-// migration to be unapplied it should not be present in the Store.
+// migration to be unapplied it should not be present in the Store. Squashed
+// migrations are applied, if all of the migrations they squash are.
 func UnappliedMigrations(store, source Source) (Migrations, error) {
-	appliedMigrations, err := store.Collect()
+	return UnappliedMigrationsContext(context.Background(), store, source)
+}
+
+// UnappliedMigrationsContext is like UnappliedMigrations, but with a context.
+func UnappliedMigrationsContext(ctx context.Context, store, source Source) (Migrations, error) {
+	appliedMigrations, err := CollectContext(ctx, store)
 	if err != nil {
 		return nil, err
 	}
 
-	incomingMigrations, err := source.Collect()
+	incomingMigrations, err := CollectContext(ctx, source)
 	if err != nil {
 		return nil, err
 	}
 
-	unappliedMigrations := appliedMigrations.Except(incomingMigrations)
+	var unappliedMigrations Migrations
+	for _, migration := range appliedMigrations.Except(incomingMigrations) {
+		if !squashedApplied(migration, appliedMigrations) {
+			unappliedMigrations = append(unappliedMigrations, migration)
+		}
+	}
 	unappliedMigrations.Sort()
 
 	return unappliedMigrations, nil
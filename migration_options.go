@@ -7,8 +7,12 @@ import (
 
 // MigrationOptions are the options for a migration. Keep in mind that some
 // options (transaction) are not supported by every RDBMS (ahem, MySQL).
+//
+// Squashes lists the versions of the migrations a migration built by Squash
+// replaces.
 type MigrationOptions struct {
-	Transaction bool `json:"transaction"`
+	Transaction bool    `json:"transaction"`
+	Squashes    []int64 `json:"squashes,omitempty"`
 }
 
 // DefaultMigrationOptions generate the default migration options.
@@ -39,6 +39,78 @@ func TestMigrationFromPath(t *testing.T) {
 	assert.Equal(t, expectedPath, m.Path)
 }
 
+func TestMigrationFromPath_Checksum(t *testing.T) {
+	expectedPath := "testdata/migrations/20170329154959_introduce_domain_model"
+
+	m, err := MigrationFromBytes(expectedPath, ioutil.ReadFile)
+	assert.Nil(t, err)
+
+	assert.Equal(t, Checksum(m.UpSQL, m.DownSQL), m.Checksum)
+	assert.Len(t, m.Checksum, 64)
+}
+
+func TestMigrationFromFile(t *testing.T) {
+	content := []byte(`-- Adds the users table.
+-- +gloat Up
+CREATE TABLE users ();
+
+-- +gloat Down
+DROP TABLE users;
+`)
+
+	m, err := MigrationFromFile("migrations/20170329154959_add_users.sql", readBytes(content))
+	assert.Nil(t, err)
+
+	assert.Equal(t, int64(20170329154959), m.Version)
+	assert.Equal(t, "migrations/20170329154959_add_users.sql", m.Path)
+	assert.Equal(t, []byte("CREATE TABLE users ();"), m.UpSQL)
+	assert.Equal(t, []byte("DROP TABLE users;"), m.DownSQL)
+	assert.True(t, m.Options.Transaction)
+	assert.Equal(t, Checksum(m.UpSQL, m.DownSQL), m.Checksum)
+}
+
+func TestMigrationFromFile_NoTransaction(t *testing.T) {
+	content := []byte(`-- +gloat NoTransaction
+-- +gloat up
+CREATE INDEX CONCURRENTLY ON users (id);
+`)
+
+	m, err := MigrationFromFile("20180905150724_concurrent_migration.sql", readBytes(content))
+	assert.Nil(t, err)
+
+	assert.Equal(t, []byte("CREATE INDEX CONCURRENTLY ON users (id);"), m.UpSQL)
+	assert.False(t, m.Reversible())
+	assert.False(t, m.Options.Transaction)
+}
+
+func TestMigrationFromFile_Invalid(t *testing.T) {
+	for _, content := range []string{
+		"CREATE TABLE users ();",
+		"CREATE TABLE users ();\n-- +gloat Up\n",
+		"-- +gloat Up\nCREATE TABLE users ();\n-- +gloat Sideways\n",
+	} {
+		_, err := MigrationFromFile("20170329154959_add_users.sql", readBytes([]byte(content)))
+		assert.Error(t, err, content)
+	}
+}
+
+func TestIsMigrationFile(t *testing.T) {
+	assert.True(t, IsMigrationFile("migrations/20170329154959_add_users.sql"))
+	assert.False(t, IsMigrationFile("migrations/20170329154959_add_users"))
+	assert.False(t, IsMigrationFile("migrations/20170329154959_add_users/options.json"))
+	assert.False(t, IsMigrationFile("migrations/structure.sql"))
+	assert.False(t, IsMigrationFile("migrations/R_users_view.sql"))
+}
+
+func TestChecksum(t *testing.T) {
+	up := []byte("CREATE TABLE users ();")
+	down := []byte("DROP TABLE users;")
+
+	assert.Equal(t, Checksum(up, down), Checksum(up, down))
+	assert.NotEqual(t, Checksum(up, down), Checksum(up, nil))
+	assert.NotEqual(t, Checksum(up, down), Checksum(down, up))
+}
+
 func TestMigrationsExcept(t *testing.T) {
 	var migrations Migrations
 
@@ -133,3 +205,9 @@ func TestMigrationsReverseSort(t *testing.T) {
 	migrations.ReverseSort()
 	assert.Equal(t, migrations[0].Version, m2.Version)
 }
+
+func readBytes(content []byte) func(string) ([]byte, error) {
+	return func(string) ([]byte, error) {
+		return content, nil
+	}
+}
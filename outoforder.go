@@ -0,0 +1,134 @@
+package gloat
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// OutOfOrderPolicy is what Gloat does with pending migrations older than the
+// latest applied one. Those usually come from a branch merged late.
+type OutOfOrderPolicy int
+
+const (
+	// OutOfOrderAllow applies out of order migrations like any other.
+	OutOfOrderAllow OutOfOrderPolicy = iota
+
+	// OutOfOrderWarn applies out of order migrations too, but they are meant
+	// to be reported, see Plan.OutOfOrder. The CLI prints them.
+	OutOfOrderWarn
+
+	// OutOfOrderFail refuses to plan out of order migrations with an
+	// OutOfOrderError.
+	OutOfOrderFail
+)
+
+// String returns the name of the policy, as accepted by
+// ParseOutOfOrderPolicy.
+func (p OutOfOrderPolicy) String() string {
+	switch p {
+	case OutOfOrderWarn:
+		return "warn"
+	case OutOfOrderFail:
+		return "error"
+	}
+
+	return "allow"
+}
+
+// ParseOutOfOrderPolicy parses a policy named allow, warn or error.
+func ParseOutOfOrderPolicy(name string) (OutOfOrderPolicy, error) {
+	switch name {
+	case "allow":
+		return OutOfOrderAllow, nil
+	case "warn":
+		return OutOfOrderWarn, nil
+	case "error":
+		return OutOfOrderFail, nil
+	}
+
+	return 0, fmt.Errorf("unsupported out of order policy %s", name)
+}
+
+// OutOfOrderError is the error returned when the OutOfOrderFail policy meets
+// pending migrations older than the Latest applied one.
+type OutOfOrderError struct {
+	Versions []int64
+	Latest   int64
+}
+
+// Error implements the error interface.
+func (err OutOfOrderError) Error() string {
+	return fmt.Sprintf("migrations %s are older than the latest applied migration %d", joinVersions(err.Versions), err.Latest)
+}
+
+// OutOfOrderMigrations returns the unapplied migrations older than the latest
+// applied one, regardless of the policy.
+func (c *Gloat) OutOfOrderMigrations() (Migrations, error) {
+	return c.OutOfOrderMigrationsContext(context.Background())
+}
+
+// OutOfOrderMigrationsContext is like OutOfOrderMigrations, but with a
+// context.
+func (c *Gloat) OutOfOrderMigrationsContext(ctx context.Context) (Migrations, error) {
+	appliedMigrations, err := CollectContext(ctx, c.Store)
+	if err != nil {
+		return nil, err
+	}
+
+	unappliedMigrations, err := c.UnappliedContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, _ := outOfOrder(appliedMigrations, unappliedMigrations)
+	return migrations, nil
+}
+
+// checkOutOfOrder returns the pending migrations older than the latest
+// applied one. If there are any and the policy is OutOfOrderFail, it returns
+// an OutOfOrderError instead.
+func (c *Gloat) checkOutOfOrder(applied, pending Migrations) (Migrations, error) {
+	migrations, latest := outOfOrder(applied, pending)
+	if len(migrations) == 0 || c.OutOfOrder != OutOfOrderFail {
+		return migrations, nil
+	}
+
+	err := OutOfOrderError{Latest: latest}
+	for _, migration := range migrations {
+		err.Versions = append(err.Versions, migration.Version)
+	}
+
+	return nil, err
+}
+
+// outOfOrder returns the pending migrations older than the latest applied one,
+// along with its version.
+func outOfOrder(applied, pending Migrations) (Migrations, int64) {
+	var latest int64
+	for _, migration := range applied {
+		if migration.Version > latest {
+			latest = migration.Version
+		}
+	}
+
+	var migrations Migrations
+	for _, migration := range pending {
+		if migration.Version < latest {
+			migrations = append(migrations, migration)
+		}
+	}
+	migrations.Sort()
+
+	return migrations, latest
+}
+
+func joinVersions(versions []int64) string {
+	names := make([]string, 0, len(versions))
+	for _, version := range versions {
+		names = append(names, strconv.FormatInt(version, 10))
+	}
+
+	return strings.Join(names, ", ")
+}
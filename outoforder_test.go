@@ -0,0 +1,36 @@
+package gloat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOutOfOrderPolicy(t *testing.T) {
+	for _, policy := range []OutOfOrderPolicy{OutOfOrderAllow, OutOfOrderWarn, OutOfOrderFail} {
+		parsed, err := ParseOutOfOrderPolicy(policy.String())
+		require.Nil(t, err)
+		assert.Equal(t, policy, parsed)
+	}
+
+	_, err := ParseOutOfOrderPolicy("ignore")
+	assert.NotNil(t, err)
+}
+
+func TestOutOfOrderMigrations(t *testing.T) {
+	gl.Source = NewFileSystemSource("testdata/migrations")
+	gl.Store = &testingStore{applied: Migrations{&Migration{Version: 20170511172647}}}
+
+	migrations, err := gl.OutOfOrderMigrations()
+	require.Nil(t, err)
+
+	require.Len(t, migrations, 1)
+	assert.Equal(t, int64(20170329154959), migrations[0].Version)
+}
+
+func TestOutOfOrderError(t *testing.T) {
+	err := OutOfOrderError{Versions: []int64{1, 2}, Latest: 3}
+
+	assert.Equal(t, "migrations 1, 2 are older than the latest applied migration 3", err.Error())
+}
@@ -0,0 +1,390 @@
+package gloat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Direction is the direction a migration is run in.
+type Direction string
+
+const (
+	// Up applies a migration.
+	Up Direction = "up"
+
+	// Down reverts a migration.
+	Down Direction = "down"
+)
+
+// DirectionError is returned when a plan limited to one direction would run a
+// migration in the other one.
+type DirectionError struct {
+	Version   int64
+	Direction Direction
+}
+
+// Error implements the error interface.
+func (err DirectionError) Error() string {
+	return fmt.Sprintf("migration %d would be run %s, which is not allowed", err.Version, err.Direction)
+}
+
+// StoreStatement is a statement a Store issues to record a migration, along
+// with its arguments.
+type StoreStatement struct {
+	Query string
+	Args  []interface{}
+}
+
+// StatementStore is a Store that can describe the statements it issues to
+// insert and remove a migration, without executing them.
+type StatementStore interface {
+	Store
+
+	InsertStatements(*Migration) []StoreStatement
+	RemoveStatements(*Migration) []StoreStatement
+}
+
+// PlanStep is a migration along with the direction it is run in. SQL is the
+// content executed for it, which is blank for Go migrations. StoreStatements
+// are the statements the Store issues to record it, if the Store is a
+// StatementStore. OutOfOrder tells whether the migration is applied after a
+// newer one.
+type PlanStep struct {
+	Migration       *Migration
+	Direction       Direction
+	SQL             string
+	StoreStatements []StoreStatement
+	OutOfOrder      bool
+}
+
+// Plan is the ordered list of steps a migration run goes through.
+type Plan []*PlanStep
+
+// Migrations returns the migrations of every step in the plan.
+func (p Plan) Migrations() Migrations {
+	migrations := make(Migrations, 0, len(p))
+	for _, step := range p {
+		migrations = append(migrations, step.Migration)
+	}
+
+	return migrations
+}
+
+// OutOfOrder returns the migrations of the steps applied after a newer
+// migration.
+func (p Plan) OutOfOrder() Migrations {
+	var migrations Migrations
+	for _, step := range p {
+		if step.OutOfOrder {
+			migrations = append(migrations, step.Migration)
+		}
+	}
+
+	return migrations
+}
+
+// Only returns a DirectionError for the first step that is not run in the
+// given direction.
+func (p Plan) Only(direction Direction) error {
+	for _, step := range p {
+		if step.Direction != direction {
+			return DirectionError{Version: step.Migration.Version, Direction: step.Direction}
+		}
+	}
+
+	return nil
+}
+
+// PlanUp returns the plan for applying all of the unapplied migrations,
+// without executing anything. The repeatable migrations that changed since
+// they were last applied come after them. It returns an OutOfOrderError, if
+// the OutOfOrder policy is OutOfOrderFail and some are older than the latest
+// applied one.
+func (c *Gloat) PlanUp() (Plan, error) {
+	return c.PlanUpContext(context.Background())
+}
+
+// PlanUpContext is like PlanUp, but with a context.
+func (c *Gloat) PlanUpContext(ctx context.Context) (Plan, error) {
+	appliedMigrations, err := CollectContext(ctx, c.Store)
+	if err != nil {
+		return nil, err
+	}
+
+	availableMigrations, err := CollectContext(ctx, c.source())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkSquashed(appliedMigrations, availableMigrations); err != nil {
+		return nil, err
+	}
+
+	migrations, err := c.UnappliedContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	outOfOrderMigrations, err := c.checkOutOfOrder(appliedMigrations, migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	repeatableMigrations, err := c.ChangedRepeatableContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(c.plan(migrations, Up).markOutOfOrder(outOfOrderMigrations), c.plan(repeatableMigrations, Up)...), nil
+}
+
+// ChangedRepeatable returns the repeatable migrations of the source that were
+// never applied or changed since they were last applied, ordered by name. The
+// Store has to be a RepeatableSource, if there are any.
+func (c *Gloat) ChangedRepeatable() (Migrations, error) {
+	return c.ChangedRepeatableContext(context.Background())
+}
+
+// ChangedRepeatableContext is like ChangedRepeatable, but with a context.
+func (c *Gloat) ChangedRepeatableContext(ctx context.Context) (Migrations, error) {
+	availableMigrations, err := CollectRepeatable(ctx, c.source())
+	if err != nil || len(availableMigrations) == 0 {
+		return nil, err
+	}
+
+	if _, ok := c.Store.(RepeatableSource); !ok {
+		return nil, ErrRepeatableUnsupported
+	}
+
+	appliedMigrations, err := CollectRepeatable(ctx, c.Store)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := make(map[string]string)
+	for _, migration := range appliedMigrations {
+		checksums[migration.Name] = migration.Checksum
+	}
+
+	var changedMigrations Migrations
+	for _, migration := range availableMigrations {
+		if checksum, applied := checksums[migration.Name]; !applied || checksum != migration.Checksum {
+			changedMigrations = append(changedMigrations, migration)
+		}
+	}
+
+	return changedMigrations, nil
+}
+
+// PlanDown returns the plan for reverting the last applied migration, without
+// executing anything. The plan is empty, if there is nothing to revert.
+func (c *Gloat) PlanDown() (Plan, error) {
+	return c.PlanDownContext(context.Background())
+}
+
+// PlanDownContext is like PlanDown, but with a context.
+func (c *Gloat) PlanDownContext(ctx context.Context) (Plan, error) {
+	migration, err := c.CurrentContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if migration == nil {
+		return nil, nil
+	}
+
+	return c.plan(Migrations{migration}, Down), nil
+}
+
+// PlanTo returns the plan for migrating to a given version, without
+// executing anything. The migrations applied after the version are reverted
+// first, then the unapplied ones up to and including it are applied. The
+// version has to be either in the source or applied, otherwise ErrNotFound is
+// returned. The OutOfOrder policy applies like in PlanUp.
+func (c *Gloat) PlanTo(version int64) (Plan, error) {
+	return c.PlanToContext(context.Background(), version)
+}
+
+// PlanToContext is like PlanTo, but with a context.
+func (c *Gloat) PlanToContext(ctx context.Context, version int64) (Plan, error) {
+	appliedMigrations, err := CollectContext(ctx, c.Store)
+	if err != nil {
+		return nil, err
+	}
+
+	availableMigrations, err := CollectContext(ctx, c.source())
+	if err != nil {
+		return nil, err
+	}
+
+	if availableMigrations.Find(version) == nil && appliedMigrations.Find(version) == nil {
+		return nil, ErrNotFound
+	}
+
+	var reverted Migrations
+	for _, migration := range appliedMigrations.Intersect(availableMigrations) {
+		if migration.Version > version {
+			reverted = append(reverted, migration)
+		}
+	}
+	reverted.ReverseSort()
+
+	var applied Migrations
+	for _, migration := range appliedMigrations.Except(availableMigrations) {
+		if migration.Version <= version && !squashedApplied(migration, appliedMigrations) {
+			applied = append(applied, migration)
+		}
+	}
+	applied.Sort()
+
+	var targeted Migrations
+	for _, migration := range availableMigrations {
+		if migration.Version <= version {
+			targeted = append(targeted, migration)
+		}
+	}
+
+	if err := checkSquashed(appliedMigrations, targeted); err != nil {
+		return nil, err
+	}
+
+	var kept Migrations
+	for _, migration := range appliedMigrations {
+		if migration.Version <= version {
+			kept = append(kept, migration)
+		}
+	}
+
+	outOfOrderMigrations, err := c.checkOutOfOrder(kept, applied)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(c.plan(reverted, Down), c.plan(applied, Up).markOutOfOrder(outOfOrderMigrations)...), nil
+}
+
+// PlanRedo returns the plan for reverting the last n applied migrations and
+// applying them again, with their current content in the source. It returns
+// an IrreversibleError, if any of them cannot be reverted, and ErrNotFound, if
+// any of them is no longer in the source. The plan is empty, if n is not
+// positive.
+func (c *Gloat) PlanRedo(n int) (Plan, error) {
+	return c.PlanRedoContext(context.Background(), n)
+}
+
+// PlanRedoContext is like PlanRedo, but with a context.
+func (c *Gloat) PlanRedoContext(ctx context.Context, n int) (Plan, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	appliedMigrations, err := CollectContext(ctx, c.Store)
+	if err != nil {
+		return nil, err
+	}
+
+	availableMigrations, err := CollectContext(ctx, c.source())
+	if err != nil {
+		return nil, err
+	}
+
+	appliedMigrations.ReverseSort()
+	if n < len(appliedMigrations) {
+		appliedMigrations = appliedMigrations[:n]
+	}
+
+	var reverted Migrations
+	for _, appliedMigration := range appliedMigrations {
+		migration := availableMigrations.Find(appliedMigration.Version)
+		if migration == nil {
+			return nil, ErrNotFound
+		}
+
+		if !migration.Reversible() {
+			return nil, IrreversibleError{migration.Version}
+		}
+
+		migration.AppliedAt = appliedMigration.AppliedAt
+		reverted = append(reverted, migration)
+	}
+
+	reapplied := make(Migrations, len(reverted))
+	copy(reapplied, reverted)
+	reapplied.Sort()
+
+	return append(c.plan(reverted, Down), c.plan(reapplied, Up)...), nil
+}
+
+// Execute runs the steps of a plan in order. It stops at the first error.
+func (c *Gloat) Execute(plan Plan) error {
+	return c.ExecuteContext(context.Background(), plan)
+}
+
+// ExecuteContext is like Execute, but with a context.
+func (c *Gloat) ExecuteContext(ctx context.Context, plan Plan) error {
+	_, err := c.execute(ctx, plan)
+	return err
+}
+
+// execute runs the steps of a plan in order and returns the migrations it ran,
+// even if an error occurred.
+func (c *Gloat) execute(ctx context.Context, plan Plan) (executed Migrations, err error) {
+	for _, step := range plan {
+		if err := c.executeStep(ctx, step); err != nil {
+			return executed, err
+		}
+
+		executed = append(executed, step.Migration)
+	}
+
+	return executed, nil
+}
+
+func (c *Gloat) executeStep(ctx context.Context, step *PlanStep) error {
+	if step.Direction == Down {
+		return c.RevertContext(ctx, step.Migration)
+	}
+
+	return c.ApplyContext(ctx, step.Migration)
+}
+
+func (p Plan) markOutOfOrder(migrations Migrations) Plan {
+	for _, step := range p {
+		step.OutOfOrder = migrations.Find(step.Migration.Version) != nil
+	}
+
+	return p
+}
+
+func (c *Gloat) plan(migrations Migrations, direction Direction) Plan {
+	store, describable := c.Store.(StatementStore)
+
+	plan := make(Plan, 0, len(migrations))
+	for _, migration := range migrations {
+		step := &PlanStep{Migration: migration, Direction: direction}
+
+		switch direction {
+		case Up:
+			step.SQL = string(migration.UpSQL)
+			if describable {
+				planned := *migration
+				c.stamp(&planned)
+				step.StoreStatements = store.InsertStatements(&planned)
+			}
+		case Down:
+			step.SQL = string(migration.DownSQL)
+			if describable {
+				step.StoreStatements = store.RemoveStatements(migration)
+			}
+		}
+
+		plan = append(plan, step)
+	}
+
+	return plan
+}
+
+func compactStatement(statement string) string {
+	return strings.Join(strings.Fields(statement), " ")
+}
@@ -0,0 +1,263 @@
+package gloat
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanUp(t *testing.T) {
+	gl.Source = NewFileSystemSource("testdata/migrations")
+	gl.Store = &testingStore{applied: Migrations{&Migration{Version: 20170329154959}}}
+
+	plan, err := gl.PlanUp()
+	require.Nil(t, err)
+
+	require.Len(t, plan, 3)
+	assert.Equal(t, int64(20170511172647), plan[0].Migration.Version)
+	assert.Equal(t, Up, plan[0].Direction)
+	assert.Equal(t, string(plan[0].Migration.UpSQL), plan[0].SQL)
+	assert.Nil(t, plan[0].StoreStatements)
+}
+
+func TestPlanDown(t *testing.T) {
+	gl.Source = NewFileSystemSource("testdata/migrations")
+	gl.Store = &testingStore{applied: Migrations{&Migration{Version: 20170329154959}}}
+
+	plan, err := gl.PlanDown()
+	require.Nil(t, err)
+
+	require.Len(t, plan, 1)
+	assert.Equal(t, int64(20170329154959), plan[0].Migration.Version)
+	assert.Equal(t, Down, plan[0].Direction)
+	assert.Equal(t, string(plan[0].Migration.DownSQL), plan[0].SQL)
+}
+
+func TestPlanDown_Empty(t *testing.T) {
+	gl.Store = &testingStore{}
+
+	plan, err := gl.PlanDown()
+	assert.Nil(t, err)
+	assert.Len(t, plan, 0)
+}
+
+func TestPlanTo(t *testing.T) {
+	gl.Source = &testingStore{
+		applied: Migrations{
+			&Migration{Version: 20190329154959, DownSQL: []byte("DROP TABLE b;")},
+			&Migration{Version: 20180329154959, DownSQL: []byte("DROP TABLE a;")},
+			&Migration{Version: 20170329154959},
+		},
+	}
+	gl.Store = &testingStore{
+		applied: Migrations{
+			&Migration{Version: 20190329154959},
+			&Migration{Version: 20180329154959},
+			&Migration{Version: 20170329154959},
+		},
+	}
+
+	plan, err := gl.PlanTo(20170329154959)
+	require.Nil(t, err)
+
+	require.Len(t, plan, 2)
+	assert.Equal(t, "DROP TABLE b;", plan[0].SQL)
+	assert.Equal(t, "DROP TABLE a;", plan[1].SQL)
+	assert.Equal(t, Migrations{plan[0].Migration, plan[1].Migration}, plan.Migrations())
+}
+
+func TestPlan_StoreStatements(t *testing.T) {
+	assert.Nil(t, cleanState(func() {
+		store, err := databaseStoreFactory(dbDriver, db)
+		require.Nil(t, err)
+
+		gl.Source = NewFileSystemSource("testdata/migrations")
+		gl.Store = store
+
+		plan, err := gl.PlanUp()
+		require.Nil(t, err)
+		require.Len(t, plan, 4)
+
+		require.Len(t, plan[0].StoreStatements, 1)
+		statement := plan[0].StoreStatements[0]
+		assert.Contains(t, statement.Query, "INSERT INTO")
+		require.Len(t, statement.Args, 8)
+		assert.Equal(t, plan[0].Migration.Version, statement.Args[0])
+		assert.Equal(t, plan[0].Migration.Checksum, statement.Args[2])
+
+		// Planning must not record anything.
+		assert.True(t, plan[0].Migration.AppliedAt.IsZero())
+
+		applied, err := store.Collect()
+		assert.Nil(t, err)
+		assert.Len(t, applied, 0)
+
+		removes := store.(StatementStore).RemoveStatements(plan[0].Migration)
+		require.Len(t, removes, 1)
+		assert.Contains(t, removes[0].Query, "DELETE FROM")
+		assert.Equal(t, []interface{}{plan[0].Migration.Version}, removes[0].Args)
+	}))
+}
+
+func TestExecute(t *testing.T) {
+	var run []string
+
+	gl.Executor = &stubbedExecutor{
+		up: func(m *Migration, _ Store) error {
+			run = append(run, "up")
+			return nil
+		},
+		down: func(m *Migration, _ Store) error {
+			run = append(run, "down")
+			return nil
+		},
+	}
+	gl.Store = &testingStore{}
+	defer func() { gl.Executor = &testingExecutor{} }()
+
+	plan := Plan{
+		{Migration: &Migration{Version: 1}, Direction: Up},
+		{Migration: &Migration{Version: 2}, Direction: Down},
+	}
+
+	assert.Nil(t, gl.Execute(plan))
+	assert.Equal(t, []string{"up", "down"}, run)
+}
+
+func TestPlanTo_Up(t *testing.T) {
+	gl.Source = NewFileSystemSource("testdata/migrations")
+	gl.Store = &testingStore{applied: Migrations{&Migration{Version: 20170329154959}}}
+
+	plan, err := gl.PlanTo(20180905150724)
+	require.Nil(t, err)
+
+	require.Len(t, plan, 2)
+	assert.Equal(t, int64(20170511172647), plan[0].Migration.Version)
+	assert.Equal(t, int64(20180905150724), plan[1].Migration.Version)
+	assert.Nil(t, plan.Only(Up))
+	assert.Equal(t, DirectionError{Version: 20170511172647, Direction: Up}, plan.Only(Down))
+}
+
+func TestPlanTo_Current(t *testing.T) {
+	gl.Source = NewFileSystemSource("testdata/migrations")
+	gl.Store = &testingStore{applied: Migrations{&Migration{Version: 20170329154959}}}
+
+	plan, err := gl.PlanTo(20170329154959)
+	assert.Nil(t, err)
+	assert.Len(t, plan, 0)
+}
+
+func TestPlanTo_NotFound(t *testing.T) {
+	gl.Source = NewFileSystemSource("testdata/migrations")
+	gl.Store = &testingStore{}
+
+	_, err := gl.PlanTo(1)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestPlanUp_OutOfOrder(t *testing.T) {
+	gl.Source = NewFileSystemSource("testdata/migrations")
+	gl.Store = &testingStore{applied: Migrations{&Migration{Version: 20180905150724}}}
+
+	plan, err := gl.PlanUp()
+	require.Nil(t, err)
+
+	require.Len(t, plan, 3)
+	assert.True(t, plan[0].OutOfOrder)
+	assert.True(t, plan[1].OutOfOrder)
+	assert.False(t, plan[2].OutOfOrder)
+
+	outOfOrder := plan.OutOfOrder()
+	require.Len(t, outOfOrder, 2)
+	assert.Equal(t, int64(20170329154959), outOfOrder[0].Version)
+	assert.Equal(t, int64(20170511172647), outOfOrder[1].Version)
+
+	gl.OutOfOrder = OutOfOrderFail
+	defer func() { gl.OutOfOrder = OutOfOrderAllow }()
+
+	_, err = gl.PlanUp()
+	assert.Equal(t, OutOfOrderError{Versions: []int64{20170329154959, 20170511172647}, Latest: 20180905150724}, err)
+
+	_, err = gl.PlanTo(20170329154959)
+	assert.Nil(t, err)
+
+	_, err = gl.PlanTo(20180920181906)
+	assert.IsType(t, OutOfOrderError{}, err)
+}
+
+func TestPlanRedo(t *testing.T) {
+	gl.Source = NewFileSystemSource("testdata/migrations")
+	gl.Store = &testingStore{applied: Migrations{&Migration{Version: 20170329154959}}}
+
+	plan, err := gl.PlanRedo(5)
+	require.Nil(t, err)
+
+	require.Len(t, plan, 2)
+	assert.Equal(t, Down, plan[0].Direction)
+	assert.Equal(t, Up, plan[1].Direction)
+	assert.Equal(t, int64(20170329154959), plan[1].Migration.Version)
+	assert.Equal(t, string(plan[1].Migration.UpSQL), plan[1].SQL)
+
+	plan, err = gl.PlanRedo(0)
+	assert.Nil(t, err)
+	assert.Len(t, plan, 0)
+
+	gl.Store = &testingStore{applied: Migrations{&Migration{Version: 20000101000000}}}
+
+	_, err = gl.PlanRedo(1)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestPlanUp_Repeatable(t *testing.T) {
+	fsys := fstest.MapFS{
+		"20170329154959_introduce_domain_model/up.sql": {Data: []byte("CREATE TABLE users (id INTEGER);")},
+		"R_users_view/up.sql":                          {Data: []byte("CREATE VIEW users_view AS SELECT id FROM users;")},
+	}
+
+	cleanState(func() {
+		defer db.Exec(`DROP VIEW IF EXISTS users_view`)
+
+		store, err := databaseStoreFactory(dbDriver, db)
+		require.Nil(t, err)
+
+		gl.Source = NewFSSource(fsys, "")
+		gl.Store = store
+		gl.Executor = NewSQLExecutor(db)
+
+		plan, err := gl.PlanUp()
+		require.Nil(t, err)
+		require.Len(t, plan, 2)
+		assert.Equal(t, int64(20170329154959), plan[0].Migration.Version)
+		assert.Equal(t, "users_view", plan[1].Migration.Name)
+
+		require.Nil(t, gl.Execute(plan))
+
+		plan, err = gl.PlanUp()
+		require.Nil(t, err)
+		assert.Len(t, plan, 0)
+
+		fsys["R_users_view/up.sql"] = &fstest.MapFile{
+			Data: []byte("DROP VIEW IF EXISTS users_view; CREATE VIEW users_view AS SELECT id AS user_id FROM users;"),
+		}
+
+		plan, err = gl.PlanUp()
+		require.Nil(t, err)
+		require.Len(t, plan, 1)
+		assert.Equal(t, "users_view", plan[0].Migration.Name)
+
+		require.Nil(t, gl.Execute(plan))
+
+		_, err = db.Exec(`SELECT user_id FROM users_view`)
+		assert.Nil(t, err)
+	})
+}
+
+func TestPlanUp_RepeatableUnsupported(t *testing.T) {
+	gl.Source = NewFSSource(fstest.MapFS{"R_users_view/up.sql": {Data: []byte("SELECT 1;")}}, "")
+	gl.Store = &testingStore{}
+
+	_, err := gl.PlanUp()
+	assert.Equal(t, ErrRepeatableUnsupported, err)
+}
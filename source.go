@@ -1,6 +1,9 @@
 package gloat
 
 import (
+	"context"
+	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -11,13 +14,62 @@ type Source interface {
 	Collect() (Migrations, error)
 }
 
+// ContextSource is a Source that can be cancelled through a context.
+type ContextSource interface {
+	Source
+
+	CollectContext(context.Context) (Migrations, error)
+}
+
+// CollectContext collects the migrations from a source with a context. If the
+// source is not a ContextSource, the context is only checked before the
+// collection.
+func CollectContext(ctx context.Context, source Source) (Migrations, error) {
+	if source, ok := source.(ContextSource); ok {
+		return source.CollectContext(ctx)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return source.Collect()
+}
+
+// RepeatableSource is a Source of repeatable migrations too. The repeatable
+// migrations are not returned by Collect.
+//
+// Stores implementing it record the repeatable migrations passed to Insert
+// and return the last applied ones from CollectRepeatable.
+type RepeatableSource interface {
+	Source
+
+	CollectRepeatable(context.Context) (Migrations, error)
+}
+
+// CollectRepeatable collects the repeatable migrations of a source, ordered by
+// name. It returns none, if the source is not a RepeatableSource.
+func CollectRepeatable(ctx context.Context, source Source) (Migrations, error) {
+	if source, ok := source.(RepeatableSource); ok {
+		return source.CollectRepeatable(ctx)
+	}
+
+	return nil, nil
+}
+
 // FileSystemSource is a file system source of migrations. The migrations are
-// stored in folders with the following structure:
+// stored in folders or single files with the following structure:
 //
 // migrations/
-// └── 20170329154959_introduce_domain_model
-//     ├── down.sql
+// ├── 20170329154959_introduce_domain_model
+// │   ├── down.sql
+// │   └── up.sql
+// ├── 20170511172647_add_user_tokens.sql
+// └── R_active_users_view
 //     └── up.sql
+//
+// Folders starting with R_ hold repeatable migrations. Single files are read
+// with MigrationFromFile.
 type FileSystemSource struct {
 	Dir string
 }
@@ -28,10 +80,44 @@ type FileSystemSource struct {
 // └── 20170329154959_introduce_domain_model
 //     ├── down.sql
 //     └── up.sql
-func (s *FileSystemSource) Collect() (migrations Migrations, err error) {
+func (s *FileSystemSource) Collect() (Migrations, error) {
+	return s.CollectContext(context.Background())
+}
+
+// CollectContext is like Collect, but stops walking the folder once the
+// context is done.
+func (s *FileSystemSource) CollectContext(ctx context.Context) (migrations Migrations, err error) {
+	migrations, err = s.collect(ctx, false)
+	migrations.Sort()
+
+	return
+}
+
+// CollectRepeatable builds the repeatable migrations stored in the folder.
+func (s *FileSystemSource) CollectRepeatable(ctx context.Context) (migrations Migrations, err error) {
+	migrations, err = s.collect(ctx, true)
+	migrations.SortByName()
+
+	return
+}
+
+func (s *FileSystemSource) collect(ctx context.Context, repeatable bool) (migrations Migrations, err error) {
 	err = filepath.Walk(s.Dir, func(path string, info os.FileInfo, err error) error {
-		if info != nil && info.IsDir() && path != s.Dir {
-			migration, err := MigrationFromBytes(path, ioutil.ReadFile)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if info != nil && info.IsDir() && path != s.Dir && IsRepeatablePath(path) == repeatable {
+			migration, err := migrationFromBytes(path, ioutil.ReadFile)
+			if err != nil {
+				return err
+			}
+
+			migrations = append(migrations, migration)
+		}
+
+		if info != nil && !info.IsDir() && !repeatable && isSingleFileMigration(filepath.Clean(s.Dir), path) {
+			migration, err := MigrationFromFile(path, ioutil.ReadFile)
 			if err != nil {
 				return err
 			}
@@ -42,8 +128,6 @@ func (s *FileSystemSource) Collect() (migrations Migrations, err error) {
 		return nil
 	})
 
-	migrations.Sort()
-
 	return
 }
 
@@ -53,6 +137,82 @@ func NewFileSystemSource(dir string) Source {
 	return &FileSystemSource{Dir: dir}
 }
 
+// FSSource is a migration source for any fs.FS, like embed.FS, os.DirFS or
+// fstest.MapFS. The migrations are stored in folders or single files with the
+// same structure FileSystemSource expects, under Dir.
+type FSSource struct {
+	FS  fs.FS
+	Dir string
+}
+
+// Collect builds migrations stored in a folder of the file system.
+func (s *FSSource) Collect() (Migrations, error) {
+	return s.CollectContext(context.Background())
+}
+
+// CollectContext is like Collect, but stops walking the folder once the
+// context is done.
+func (s *FSSource) CollectContext(ctx context.Context) (migrations Migrations, err error) {
+	migrations, err = s.collect(ctx, false)
+	migrations.Sort()
+
+	return
+}
+
+// CollectRepeatable builds the repeatable migrations stored in the folder.
+func (s *FSSource) CollectRepeatable(ctx context.Context) (migrations Migrations, err error) {
+	migrations, err = s.collect(ctx, true)
+	migrations.SortByName()
+
+	return
+}
+
+func (s *FSSource) collect(ctx context.Context, repeatable bool) (migrations Migrations, err error) {
+	dir := s.Dir
+	if dir == "" {
+		dir = "."
+	}
+
+	read := func(name string) ([]byte, error) {
+		return fs.ReadFile(s.FS, filepath.ToSlash(name))
+	}
+
+	err = fs.WalkDir(s.FS, dir, func(path string, d fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if d != nil && d.IsDir() && path != dir && IsRepeatablePath(path) == repeatable {
+			migration, err := migrationFromBytes(path, read)
+			if err != nil {
+				return err
+			}
+
+			migrations = append(migrations, migration)
+		}
+
+		if d != nil && !d.IsDir() && !repeatable && isSingleFileMigration(dir, path) {
+			migration, err := MigrationFromFile(path, read)
+			if err != nil {
+				return err
+			}
+
+			migrations = append(migrations, migration)
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// NewFSSource creates a new source of migrations that takes them out of a
+// folder in an fs.FS. Use it with embed.FS to ship the migrations inside the
+// program without code generation.
+func NewFSSource(fsys fs.FS, dir string) Source {
+	return &FSSource{FS: fsys, Dir: dir}
+}
+
 // AssetSource is a go-bindata migration source for binary embedded migrations.
 // You need to pass a prefix, the Asset and AssetDir functions, go-bindata
 // generates.
@@ -63,7 +223,29 @@ type AssetSource struct {
 }
 
 // Collect builds migrations from a go-bindata embedded migrations.
-func (s *AssetSource) Collect() (migrations Migrations, err error) {
+func (s *AssetSource) Collect() (Migrations, error) {
+	return s.CollectContext(context.Background())
+}
+
+// CollectContext is like Collect, but stops reading the assets once the
+// context is done.
+func (s *AssetSource) CollectContext(ctx context.Context) (migrations Migrations, err error) {
+	migrations, err = s.collect(ctx, false)
+	migrations.Sort()
+
+	return
+}
+
+// CollectRepeatable builds the repeatable migrations from the go-bindata
+// embedded migrations.
+func (s *AssetSource) CollectRepeatable(ctx context.Context) (migrations Migrations, err error) {
+	migrations, err = s.collect(ctx, true)
+	migrations.SortByName()
+
+	return
+}
+
+func (s *AssetSource) collect(ctx context.Context, repeatable bool) (migrations Migrations, err error) {
 	dirs, err := s.AssetDir(s.Prefix)
 	if err != nil {
 		return
@@ -72,7 +254,23 @@ func (s *AssetSource) Collect() (migrations Migrations, err error) {
 	for _, path := range dirs {
 		var migration *Migration
 
-		migration, err = MigrationFromBytes(filepath.Join(s.Prefix, path), s.Asset)
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if IsRepeatablePath(path) != repeatable {
+			continue
+		}
+
+		if filepath.Ext(path) == ".sql" {
+			if repeatable || !IsMigrationFile(path) {
+				continue
+			}
+
+			migration, err = MigrationFromFile(filepath.Join(s.Prefix, path), s.Asset)
+		} else {
+			migration, err = migrationFromBytes(filepath.Join(s.Prefix, path), s.Asset)
+		}
 		if err != nil {
 			return
 		}
@@ -80,8 +278,6 @@ func (s *AssetSource) Collect() (migrations Migrations, err error) {
 		migrations = append(migrations, migration)
 	}
 
-	migrations.Sort()
-
 	return
 }
 
@@ -90,3 +286,91 @@ func (s *AssetSource) Collect() (migrations Migrations, err error) {
 func NewAssetSource(prefix string, asset func(string) ([]byte, error), assetDir func(string) ([]string, error)) Source {
 	return &AssetSource{Prefix: prefix, Asset: asset, AssetDir: assetDir}
 }
+
+// MultiSource merges the migrations of several sources, e.g. SQL migrations
+// from a FileSystemSource and Go migrations from a GoSource. The migrations
+// are ordered by version across all of the sources.
+type MultiSource struct {
+	Sources []Source
+}
+
+// Collect builds the migrations of all of the sources. Two migrations with
+// the same version are an error.
+func (s *MultiSource) Collect() (Migrations, error) {
+	return s.CollectContext(context.Background())
+}
+
+// CollectContext is like Collect, but with a context.
+func (s *MultiSource) CollectContext(ctx context.Context) (Migrations, error) {
+	var migrations Migrations
+
+	seen := make(map[int64]string)
+	for _, source := range s.Sources {
+		collected, err := CollectContext(ctx, source)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, migration := range collected {
+			if path, ok := seen[migration.Version]; ok {
+				return nil, fmt.Errorf("duplicate migration version %d in %s and %s", migration.Version, path, migration.Path)
+			}
+			seen[migration.Version] = migration.Path
+
+			migrations = append(migrations, migration)
+		}
+	}
+
+	migrations.Sort()
+
+	return migrations, nil
+}
+
+// CollectRepeatable builds the repeatable migrations of all of the sources
+// that have them. Two repeatable migrations with the same name are an error.
+func (s *MultiSource) CollectRepeatable(ctx context.Context) (Migrations, error) {
+	var migrations Migrations
+
+	seen := make(map[string]string)
+	for _, source := range s.Sources {
+		collected, err := CollectRepeatable(ctx, source)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, migration := range collected {
+			if path, ok := seen[migration.Name]; ok {
+				return nil, fmt.Errorf("duplicate repeatable migration %s in %s and %s", migration.Name, path, migration.Path)
+			}
+			seen[migration.Name] = migration.Path
+
+			migrations = append(migrations, migration)
+		}
+	}
+
+	migrations.SortByName()
+
+	return migrations, nil
+}
+
+// NewMultiSource creates a source that merges the migrations of several
+// sources.
+func NewMultiSource(sources ...Source) Source {
+	return &MultiSource{Sources: sources}
+}
+
+// migrationFromBytes builds a repeatable or a versioned migration, depending
+// on the name of its folder.
+func migrationFromBytes(path string, read func(string) ([]byte, error)) (*Migration, error) {
+	if IsRepeatablePath(path) {
+		return RepeatableMigrationFromBytes(path, read)
+	}
+
+	return MigrationFromBytes(path, read)
+}
+
+// isSingleFileMigration reports whether a file right in the migrations folder
+// is a single file migration.
+func isSingleFileMigration(dir, path string) bool {
+	return filepath.Dir(path) == dir && IsMigrationFile(path) && !IsRepeatablePath(path)
+}
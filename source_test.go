@@ -1,11 +1,15 @@
 package gloat
 
 import (
+	"context"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
+	"testing/fstest"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFileSystemSourceCollect(t *testing.T) {
@@ -50,3 +54,134 @@ func TestAssetSourceDoesNotBreakOnIrreversibleMigrations(t *testing.T) {
 
 	assert.Len(t, migrations, 4)
 }
+
+func TestFileSystemSourceCollectContext_Cancelled(t *testing.T) {
+	fs := NewFileSystemSource("testdata/migrations").(ContextSource)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := fs.CollectContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestMultiSourceCollect(t *testing.T) {
+	goSource := NewGoSource()
+	goSource.Register(20180101000000, "backfill_users", noopMigrationFunc, nil)
+
+	src := NewMultiSource(NewFileSystemSource("testdata/migrations"), goSource)
+
+	migrations, err := src.Collect()
+	assert.Nil(t, err)
+
+	assert.Len(t, migrations, 5)
+	assert.Equal(t, int64(20170511172647), migrations[1].Version)
+	assert.Equal(t, int64(20180101000000), migrations[2].Version)
+	assert.NotNil(t, migrations[2].UpFunc)
+}
+
+func TestMultiSourceCollect_Duplicate(t *testing.T) {
+	goSource := NewGoSource()
+	goSource.Register(20170329154959, "introduce_domain_model", noopMigrationFunc, nil)
+
+	src := NewMultiSource(NewFileSystemSource("testdata/migrations"), goSource)
+
+	_, err := src.Collect()
+	assert.Error(t, err)
+}
+
+func TestFSSourceCollect(t *testing.T) {
+	fs := NewFSSource(os.DirFS("testdata"), "migrations")
+
+	migrations, err := fs.Collect()
+	assert.Nil(t, err)
+
+	assert.Len(t, migrations, 4)
+	assert.Equal(t, int64(20170329154959), migrations[0].Version)
+	assert.Equal(t, "migrations/20170329154959_introduce_domain_model", migrations[0].Path)
+	assert.True(t, migrations[0].Reversible())
+	assert.False(t, migrations[1].Reversible())
+	assert.False(t, migrations[2].Options.Transaction)
+}
+
+func TestFSSourceCollect_MapFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"20170329154959_introduce_domain_model/up.sql":   {Data: []byte("CREATE TABLE users ();")},
+		"20170329154959_introduce_domain_model/down.sql": {Data: []byte("DROP TABLE users;")},
+		"20180905150724_concurrent_migration/up.sql":     {Data: []byte("CREATE INDEX CONCURRENTLY ON users (id);")},
+		"20180905150724_concurrent_migration/options.json": {
+			Data: []byte(`{"transaction": false}`),
+		},
+	}
+
+	migrations, err := NewFSSource(fsys, "").Collect()
+	assert.Nil(t, err)
+
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, []byte("CREATE TABLE users ();"), migrations[0].UpSQL)
+	assert.Equal(t, []byte("DROP TABLE users;"), migrations[0].DownSQL)
+	assert.False(t, migrations[1].Reversible())
+	assert.False(t, migrations[1].Options.Transaction)
+}
+
+func TestFSSourceCollect_SingleFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/20170329154959_introduce_domain_model/up.sql": {Data: []byte("CREATE TABLE users ();")},
+		"migrations/20170511172647_add_user_tokens.sql": {
+			Data: []byte("-- +gloat Up\nALTER TABLE users ADD token TEXT;\n-- +gloat Down\nALTER TABLE users DROP token;\n"),
+		},
+		"migrations/README.md":     {Data: []byte("Migrations.")},
+		"migrations/structure.sql": {Data: []byte("CREATE TABLE users ();")},
+	}
+
+	migrations, err := NewFSSource(fsys, "migrations").Collect()
+	require.Nil(t, err)
+
+	require.Len(t, migrations, 2)
+	assert.Equal(t, "migrations/20170329154959_introduce_domain_model", migrations[0].Path)
+	assert.Equal(t, "migrations/20170511172647_add_user_tokens.sql", migrations[1].Path)
+	assert.Equal(t, []byte("ALTER TABLE users ADD token TEXT;"), migrations[1].UpSQL)
+	assert.Equal(t, []byte("ALTER TABLE users DROP token;"), migrations[1].DownSQL)
+}
+
+func TestFSSourceCollect_Empty(t *testing.T) {
+	migrations, err := NewFSSource(fstest.MapFS{}, "migrations").Collect()
+	assert.Nil(t, err)
+
+	assert.Len(t, migrations, 0)
+}
+
+func TestFSSourceCollectRepeatable(t *testing.T) {
+	fsys := fstest.MapFS{
+		"20170329154959_introduce_domain_model/up.sql": {Data: []byte("CREATE TABLE users ();")},
+		"R_users_view/up.sql":                          {Data: []byte("CREATE VIEW users_view AS SELECT * FROM users;")},
+		"R_active_users_view/up.sql":                   {Data: []byte("CREATE VIEW active_users AS SELECT * FROM users;")},
+	}
+
+	src := NewFSSource(fsys, "")
+
+	migrations, err := src.Collect()
+	require.Nil(t, err)
+	require.Len(t, migrations, 1)
+	assert.False(t, migrations[0].Repeatable)
+
+	migrations, err = CollectRepeatable(context.Background(), src)
+	require.Nil(t, err)
+	require.Len(t, migrations, 2)
+	assert.Equal(t, "active_users_view", migrations[0].Name)
+	assert.Equal(t, "users_view", migrations[1].Name)
+	assert.True(t, migrations[0].Repeatable)
+	assert.Equal(t, int64(0), migrations[0].Version)
+	assert.Equal(t, Checksum([]byte("CREATE VIEW active_users AS SELECT * FROM users;"), nil), migrations[0].Checksum)
+}
+
+func TestMultiSourceCollectRepeatable_Duplicate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"R_users_view/up.sql": {Data: []byte("CREATE VIEW users_view AS SELECT * FROM users;")},
+	}
+
+	src := NewMultiSource(NewFSSource(fsys, ""), NewFSSource(fsys, ""), NewGoSource())
+
+	_, err := CollectRepeatable(context.Background(), src)
+	assert.Error(t, err)
+}
@@ -0,0 +1,336 @@
+package gloat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dialect is the SQL dialect of a database, used to read migrations the way
+// the database does.
+type Dialect int
+
+const (
+	// PostgreSQL reads dollar quoted bodies, E'' strings with backslash
+	// escapes and nested block comments.
+	PostgreSQL Dialect = iota + 1
+
+	// MySQL reads backslash escapes in strings, backtick quoted names, #
+	// comments and the DELIMITER command of the mysql client.
+	MySQL
+
+	// SQLite3 reads backtick and bracket quoted names and the BEGIN ... END
+	// bodies of triggers.
+	SQLite3
+)
+
+// Statement is a single statement of a migration. Offset is the byte offset
+// of the statement in the migration content and Line is its 1-based line.
+type Statement struct {
+	SQL    string
+	Offset int
+	Line   int
+}
+
+// StatementError is the error returned when a statement of a migration
+// fails. Index is the 0-based index of the statement in the migration.
+type StatementError struct {
+	Index     int
+	Statement Statement
+	Err       error
+}
+
+// Error implements the error interface.
+func (err StatementError) Error() string {
+	return fmt.Sprintf("statement %d at line %d: %v\n%s", err.Index+1, err.Statement.Line, err.Err, err.Statement.SQL)
+}
+
+// Unwrap returns the error of the statement.
+func (err StatementError) Unwrap() error {
+	return err.Err
+}
+
+// SplitStatements splits the content of a migration into statements, the way
+// the given dialect reads it. Statements are separated by semicolons outside
+// of string literals, quoted names and comments. Comments before a statement
+// and blank statements are dropped.
+func SplitStatements(content []byte, dialect Dialect) ([]Statement, error) {
+	s := &splitter{src: string(content), dialect: dialect, delimiter: ";", start: -1}
+	return s.split()
+}
+
+type splitter struct {
+	src        string
+	dialect    Dialect
+	delimiter  string
+	start      int
+	statements []Statement
+
+	// The first words of the statement and the BEGIN and CASE ... END nesting
+	// in it, to find the end of SQLite3 triggers.
+	words []string
+	depth int
+}
+
+func (s *splitter) split() ([]Statement, error) {
+	i := 0
+	for i < len(s.src) {
+		c := s.src[i]
+
+		switch {
+		case s.start == -1 && s.dialect == MySQL && s.isDelimiterCommand(i):
+			next, err := s.delimiterCommand(i)
+			if err != nil {
+				return nil, err
+			}
+			i = next
+			continue
+		case strings.HasPrefix(s.src[i:], s.delimiter) && !s.inTriggerBody(i):
+			s.end(i)
+			i += len(s.delimiter)
+			continue
+		case s.isLineComment(i):
+			i = s.skipLine(i)
+			continue
+		case strings.HasPrefix(s.src[i:], "/*"):
+			next, err := s.skipBlockComment(i)
+			if err != nil {
+				return nil, err
+			}
+			i = next
+			continue
+		case isSpace(c):
+			i++
+			continue
+		}
+
+		if s.start == -1 {
+			s.start = i
+		}
+
+		var err error
+		switch {
+		case c == '\'':
+			i, err = s.skipQuoted(i, '\'', s.backslashEscapes(i))
+		case c == '"':
+			i, err = s.skipQuoted(i, '"', s.dialect == MySQL)
+		case c == '`' && s.dialect != PostgreSQL:
+			i, err = s.skipQuoted(i, '`', false)
+		case c == '[' && s.dialect == SQLite3:
+			i, err = s.skipUntil(i, "]", "quoted name")
+		case c == '$' && s.dialect == PostgreSQL:
+			if tag, ok := s.dollarTag(i); ok {
+				i, err = s.skipUntil(i+len(tag)-1, tag, "dollar quoted string")
+			} else {
+				i++
+			}
+		case isIdent(c) && s.dialect == SQLite3:
+			i = s.word(i)
+		default:
+			i++
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.end(len(s.src))
+
+	return s.statements, nil
+}
+
+func (s *splitter) end(i int) {
+	if s.start == -1 {
+		return
+	}
+
+	s.statements = append(s.statements, Statement{
+		SQL:    strings.TrimSpace(s.src[s.start:i]),
+		Offset: s.start,
+		Line:   s.line(s.start),
+	})
+	s.start = -1
+	s.words = nil
+	s.depth = 0
+}
+
+func (s *splitter) line(offset int) int {
+	return strings.Count(s.src[:offset], "\n") + 1
+}
+
+func (s *splitter) isLineComment(i int) bool {
+	if s.dialect == MySQL {
+		if s.src[i] == '#' {
+			return true
+		}
+
+		// MySQL needs a space after --, so 1--1 stays an expression.
+		return strings.HasPrefix(s.src[i:], "--") && (i+2 == len(s.src) || isSpace(s.src[i+2]))
+	}
+
+	return strings.HasPrefix(s.src[i:], "--")
+}
+
+func (s *splitter) skipLine(i int) int {
+	if j := strings.IndexByte(s.src[i:], '\n'); j != -1 {
+		return i + j + 1
+	}
+
+	return len(s.src)
+}
+
+func (s *splitter) skipBlockComment(i int) (int, error) {
+	depth := 0
+	for j := i; j < len(s.src); j++ {
+		switch {
+		case strings.HasPrefix(s.src[j:], "/*"):
+			// Only PostgreSQL nests block comments.
+			if depth == 0 || s.dialect == PostgreSQL {
+				depth++
+			}
+			j++
+		case strings.HasPrefix(s.src[j:], "*/"):
+			depth--
+			j++
+			if depth == 0 {
+				return j + 1, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("unterminated block comment at line %d", s.line(i))
+}
+
+func (s *splitter) skipQuoted(i int, quote byte, backslashEscapes bool) (int, error) {
+	for j := i + 1; j < len(s.src); j++ {
+		switch s.src[j] {
+		case '\\':
+			if backslashEscapes {
+				j++
+			}
+		case quote:
+			// A doubled quote is an escaped one.
+			if j+1 < len(s.src) && s.src[j+1] == quote {
+				j++
+				continue
+			}
+
+			return j + 1, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unterminated %c quoted string at line %d", quote, s.line(i))
+}
+
+func (s *splitter) skipUntil(i int, closing string, what string) (int, error) {
+	j := strings.Index(s.src[i+1:], closing)
+	if j == -1 {
+		return 0, fmt.Errorf("unterminated %s at line %d", what, s.line(i))
+	}
+
+	return i + 1 + j + len(closing), nil
+}
+
+// backslashEscapes reports whether the string starting at i reads backslash
+// escapes, which are MySQL strings and PostgreSQL E'...' strings.
+func (s *splitter) backslashEscapes(i int) bool {
+	switch s.dialect {
+	case MySQL:
+		return true
+	case PostgreSQL:
+		return i > 0 && (s.src[i-1] == 'E' || s.src[i-1] == 'e') && (i < 2 || !isIdent(s.src[i-2]))
+	}
+
+	return false
+}
+
+// dollarTag returns the $tag$ starting a PostgreSQL dollar quoted string at i.
+// Positional parameters like $1 are not tags.
+func (s *splitter) dollarTag(i int) (string, bool) {
+	if i > 0 && isIdent(s.src[i-1]) {
+		return "", false
+	}
+
+	for j := i + 1; j < len(s.src); j++ {
+		c := s.src[j]
+		if c == '$' {
+			return s.src[i : j+1], true
+		}
+		if !isIdent(c) || (j == i+1 && c >= '0' && c <= '9') {
+			return "", false
+		}
+	}
+
+	return "", false
+}
+
+// word skips the word at i, keeping track of the BEGIN, CASE and END keywords
+// of SQLite3 triggers.
+func (s *splitter) word(i int) int {
+	j := i
+	for j < len(s.src) && isIdent(s.src[j]) {
+		j++
+	}
+
+	word := strings.ToUpper(s.src[i:j])
+	if len(s.words) < 3 {
+		s.words = append(s.words, word)
+	}
+
+	switch word {
+	case "BEGIN", "CASE":
+		s.depth++
+	case "END":
+		s.depth--
+	}
+
+	return j
+}
+
+// inTriggerBody reports whether a semicolon at i is inside the BEGIN ... END
+// body of an SQLite3 trigger, where it does not end the statement. The CASE
+// ... END expressions in the body are nested in it.
+func (s *splitter) inTriggerBody(i int) bool {
+	if s.dialect != SQLite3 || s.start == -1 || len(s.words) < 2 || s.words[0] != "CREATE" {
+		return false
+	}
+
+	kind := s.words[1]
+	if (kind == "TEMP" || kind == "TEMPORARY") && len(s.words) > 2 {
+		kind = s.words[2]
+	}
+	if kind != "TRIGGER" {
+		return false
+	}
+
+	return s.depth > 0
+}
+
+func (s *splitter) isDelimiterCommand(i int) bool {
+	const command = "DELIMITER"
+
+	if len(s.src)-i <= len(command) || !strings.EqualFold(s.src[i:i+len(command)], command) {
+		return false
+	}
+
+	return isSpace(s.src[i+len(command)])
+}
+
+func (s *splitter) delimiterCommand(i int) (int, error) {
+	next := s.skipLine(i)
+
+	fields := strings.Fields(s.src[i:next])
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("invalid DELIMITER command at line %d", s.line(i))
+	}
+
+	s.delimiter = fields[1]
+	return next, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
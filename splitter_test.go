@@ -0,0 +1,125 @@
+package gloat
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func statementsSQL(statements []Statement) []string {
+	var sql []string
+	for _, statement := range statements {
+		sql = append(sql, statement.SQL)
+	}
+
+	return sql
+}
+
+func TestSplitStatements(t *testing.T) {
+	statements, err := SplitStatements([]byte(`
+-- Create the users.
+CREATE TABLE users (id INTEGER);
+
+/* Seed; them. */
+INSERT INTO users VALUES (1) -- trailing; comment
+;
+INSERT INTO notes VALUES ('it''s; fine', "a;b")`), SQLite3)
+	require.Nil(t, err)
+
+	assert.Equal(t, []string{
+		"CREATE TABLE users (id INTEGER)",
+		"INSERT INTO users VALUES (1) -- trailing; comment",
+		`INSERT INTO notes VALUES ('it''s; fine', "a;b")`,
+	}, statementsSQL(statements))
+
+	assert.Equal(t, 3, statements[0].Line)
+	assert.Equal(t, 6, statements[1].Line)
+	assert.Equal(t, 8, statements[2].Line)
+}
+
+func TestSplitStatements_Blank(t *testing.T) {
+	statements, err := SplitStatements([]byte("  -- Nothing here.\n;;\n"), PostgreSQL)
+	assert.Nil(t, err)
+	assert.Len(t, statements, 0)
+}
+
+func TestSplitStatements_PostgreSQLDollarQuotes(t *testing.T) {
+	statements, err := SplitStatements([]byte(`
+CREATE FUNCTION touch() RETURNS trigger AS $body$
+BEGIN
+  NEW.updated_at = now(); -- $$ is fine here
+  RETURN NEW;
+END;
+$body$ LANGUAGE plpgsql;
+SELECT $1, $$a;b$$, E'\';', /* /* nested; */ */ 1;`), PostgreSQL)
+	require.Nil(t, err)
+
+	require.Len(t, statements, 2)
+	assert.Contains(t, statements[0].SQL, "RETURN NEW;")
+	assert.Equal(t, `SELECT $1, $$a;b$$, E'\';', /* /* nested; */ */ 1`, statements[1].SQL)
+}
+
+func TestSplitStatements_MySQLDelimiter(t *testing.T) {
+	statements, err := SplitStatements([]byte(`
+DELIMITER //
+CREATE PROCEDURE touch()
+BEGIN
+  UPDATE users SET name = 'a\';b'; # a comment;
+END//
+DELIMITER ;
+SELECT 1--1;
+CALL touch();`), MySQL)
+	require.Nil(t, err)
+
+	require.Len(t, statements, 3)
+	assert.Contains(t, statements[0].SQL, "# a comment;\nEND")
+	assert.Equal(t, "SELECT 1--1", statements[1].SQL)
+	assert.Equal(t, "CALL touch()", statements[2].SQL)
+}
+
+func TestSplitStatements_SQLite3Trigger(t *testing.T) {
+	statements, err := SplitStatements([]byte(`
+CREATE TEMP TRIGGER touch AFTER UPDATE ON users
+BEGIN
+  UPDATE users SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+  SELECT [odd;name] FROM users;
+END;
+DROP TABLE [odd;table];`), SQLite3)
+	require.Nil(t, err)
+
+	require.Len(t, statements, 2)
+	assert.Contains(t, statements[0].SQL, "SELECT [odd;name] FROM users;\nEND")
+	assert.Equal(t, "DROP TABLE [odd;table]", statements[1].SQL)
+
+	statements, err = SplitStatements([]byte(`
+CREATE TRIGGER audit AFTER INSERT ON users
+BEGIN
+  UPDATE users SET kind = CASE WHEN NEW.id = 1 THEN 'end;' ELSE 2 END;
+  INSERT INTO audit (id) VALUES (NEW.id);
+END;
+SELECT CASE WHEN 1 THEN 1 END;
+SELECT 2;`), SQLite3)
+	require.Nil(t, err)
+
+	require.Len(t, statements, 3)
+	assert.Contains(t, statements[0].SQL, "INSERT INTO audit (id) VALUES (NEW.id);\nEND")
+	assert.Equal(t, "SELECT CASE WHEN 1 THEN 1 END", statements[1].SQL)
+	assert.Equal(t, "SELECT 2", statements[2].SQL)
+}
+
+func TestSplitStatements_Unterminated(t *testing.T) {
+	for _, content := range []string{"SELECT 'a;", "SELECT 1 /* a;", "SELECT $x$ a;"} {
+		_, err := SplitStatements([]byte(content), PostgreSQL)
+		assert.NotNil(t, err, content)
+	}
+}
+
+func TestStatementError(t *testing.T) {
+	cause := errors.New("syntax error")
+	err := StatementError{Index: 1, Statement: Statement{SQL: "CREATE TABL users", Line: 3}, Err: cause}
+
+	assert.Equal(t, "statement 2 at line 3: syntax error\nCREATE TABL users", err.Error())
+	assert.True(t, errors.Is(err, cause))
+}
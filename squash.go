@@ -0,0 +1,130 @@
+package gloat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// SquashError is the error returned when a squashed migration is unapplied,
+// but some of the migrations it squashes are applied. The Missing ones have to
+// be applied with the original migrations first.
+type SquashError struct {
+	Version int64
+	Missing []int64
+}
+
+// Error implements the error interface.
+func (err SquashError) Error() string {
+	return fmt.Sprintf("migration %d squashes the unapplied migrations %s, apply them from the original migrations first", err.Version, joinVersions(err.Missing))
+}
+
+// Squash builds a single migration out of the migrations up to and including
+// a version. The UP content is the concatenated UP content of the squashed
+// migrations and the squashed versions are recorded in its Options. It has
+// the given version, so the databases that applied the squashed migrations
+// already have it applied. It is irreversible and runs in a transaction only
+// if all of the squashed migrations do.
+func Squash(migrations Migrations, version int64) (*Migration, error) {
+	if migrations.Find(version) == nil {
+		return nil, ErrNotFound
+	}
+
+	var squashed Migrations
+	for _, migration := range migrations {
+		if migration.Version <= version {
+			squashed = append(squashed, migration)
+		}
+	}
+	squashed.Sort()
+
+	var upSQL bytes.Buffer
+
+	options := DefaultMigrationOptions()
+	for _, migration := range squashed {
+		if migration.UpFunc != nil {
+			return nil, errors.New("cannot squash the Go migration " + migration.Path)
+		}
+
+		if !migration.Options.Transaction {
+			options.Transaction = false
+		}
+
+		// Squashing a squashed migration keeps the versions it squashed.
+		if len(migration.Options.Squashes) != 0 {
+			options.Squashes = append(options.Squashes, migration.Options.Squashes...)
+		} else {
+			options.Squashes = append(options.Squashes, migration.Version)
+		}
+
+		if upSQL.Len() != 0 {
+			upSQL.WriteString("\n")
+		}
+		fmt.Fprintf(&upSQL, "-- Squashed %s\n", filepath.Base(migration.Path))
+		upSQL.Write(bytes.TrimSpace(migration.UpSQL))
+		upSQL.WriteString("\n")
+	}
+
+	sort.Slice(options.Squashes, func(i, j int) bool {
+		return options.Squashes[i] < options.Squashes[j]
+	})
+
+	return &Migration{
+		UpSQL:    upSQL.Bytes(),
+		Path:     generateMigrationPath(version, "squashed"),
+		Version:  version,
+		Options:  options,
+		Checksum: Checksum(upSQL.Bytes(), nil),
+	}, nil
+}
+
+// checkSquashed returns a SquashError for the first migration of the source
+// that squashes some applied migrations, but not all of them. It does not
+// matter whether the squashed migration itself is applied.
+func checkSquashed(applied, migrations Migrations) error {
+	for _, migration := range migrations {
+		if missing := squashedPartially(migration, applied); len(missing) != 0 {
+			return SquashError{Version: migration.Version, Missing: missing}
+		}
+	}
+
+	return nil
+}
+
+// squashedPartially returns the versions a migration squashes that are not
+// applied, if some of the others are. A squashed migration applied as such,
+// instead of the original migration with its version, applied none of them.
+func squashedPartially(migration *Migration, applied Migrations) []int64 {
+	missing := squashedMissing(migration, applied)
+
+	squashes := len(migration.Options.Squashes)
+	if applied.Find(migration.Version) != nil {
+		squashes--
+	}
+
+	if len(missing) == 0 || len(missing) >= squashes {
+		return nil
+	}
+
+	return missing
+}
+
+// squashedMissing returns the versions a migration squashes that are not
+// applied.
+func squashedMissing(migration *Migration, applied Migrations) (missing []int64) {
+	for _, version := range migration.Options.Squashes {
+		if applied.Find(version) == nil {
+			missing = append(missing, version)
+		}
+	}
+
+	return
+}
+
+// squashedApplied reports whether a migration squashes migrations that are all
+// applied, which makes it applied too.
+func squashedApplied(migration *Migration, applied Migrations) bool {
+	return len(migration.Options.Squashes) != 0 && len(squashedMissing(migration, applied)) == 0
+}
@@ -0,0 +1,120 @@
+package gloat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSquash(t *testing.T) {
+	migrations, err := NewFileSystemSource("testdata/migrations").Collect()
+	require.Nil(t, err)
+
+	squashed, err := Squash(migrations, 20180905150724)
+	require.Nil(t, err)
+
+	assert.Equal(t, int64(20180905150724), squashed.Version)
+	assert.Equal(t, "20180905150724_squashed", squashed.Path)
+	assert.Equal(t, []int64{20170329154959, 20170511172647, 20180905150724}, squashed.Options.Squashes)
+	assert.False(t, squashed.Options.Transaction)
+	assert.False(t, squashed.Reversible())
+
+	assert.Contains(t, string(squashed.UpSQL), "-- Squashed 20170329154959_introduce_domain_model\n")
+	assert.Contains(t, string(squashed.UpSQL), string(migrations[0].UpSQL))
+	assert.Equal(t, Checksum(squashed.UpSQL, nil), squashed.Checksum)
+
+	_, err = Squash(migrations, 20000101000000)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestSquash_Squashed(t *testing.T) {
+	migrations := Migrations{
+		&Migration{Version: 3, Path: "3_squashed", Options: MigrationOptions{Transaction: true, Squashes: []int64{1, 2, 3}}},
+		&Migration{Version: 4, Path: "4_add_users", Options: DefaultMigrationOptions()},
+	}
+
+	squashed, err := Squash(migrations, 4)
+	require.Nil(t, err)
+
+	assert.Equal(t, []int64{1, 2, 3, 4}, squashed.Options.Squashes)
+	assert.True(t, squashed.Options.Transaction)
+}
+
+func TestSquash_GoMigration(t *testing.T) {
+	migrations := Migrations{
+		&Migration{Version: 1, UpFunc: noopMigrationFunc, Options: DefaultMigrationOptions()},
+	}
+
+	_, err := Squash(migrations, 1)
+	assert.Error(t, err)
+}
+
+func TestPlanUp_Squashed(t *testing.T) {
+	gl.Source = &testingStore{
+		applied: Migrations{
+			&Migration{Version: 5, Options: MigrationOptions{Squashes: []int64{1, 2, 3}}},
+			&Migration{Version: 6},
+		},
+	}
+
+	// All of the squashed migrations are applied, so is the squashed one.
+	gl.Store = &testingStore{applied: Migrations{&Migration{Version: 1}, &Migration{Version: 2}, &Migration{Version: 3}}}
+
+	plan, err := gl.PlanUp()
+	require.Nil(t, err)
+	require.Len(t, plan, 1)
+	assert.Equal(t, int64(6), plan[0].Migration.Version)
+
+	report, err := gl.Status()
+	require.Nil(t, err)
+	require.Len(t, report, 5)
+	assert.Equal(t, StateSquashed, report[0].State)
+	assert.Equal(t, StateApplied, report[3].State)
+	assert.Equal(t, StatePending, report[4].State)
+
+	// Some of the squashed migrations are applied.
+	gl.Store = &testingStore{applied: Migrations{&Migration{Version: 1}}}
+
+	_, err = gl.PlanUp()
+	assert.Equal(t, SquashError{Version: 5, Missing: []int64{2, 3}}, err)
+
+	// None of the squashed migrations are applied.
+	gl.Store = &testingStore{}
+
+	plan, err = gl.PlanUp()
+	require.Nil(t, err)
+	assert.Len(t, plan, 2)
+}
+
+func TestPlanUp_SquashedApplied(t *testing.T) {
+	gl.Source = &testingStore{
+		applied: Migrations{
+			&Migration{Version: 3, Options: MigrationOptions{Squashes: []int64{1, 2, 3}}},
+		},
+	}
+
+	// The squashed migration was applied as such.
+	gl.Store = &testingStore{applied: Migrations{&Migration{Version: 3}}}
+
+	plan, err := gl.PlanUp()
+	require.Nil(t, err)
+	assert.Len(t, plan, 0)
+
+	// The original 3 was applied, but not 2, e.g. out of order.
+	gl.Store = &testingStore{applied: Migrations{&Migration{Version: 1}, &Migration{Version: 3}}}
+
+	_, err = gl.PlanUp()
+	assert.Equal(t, SquashError{Version: 3, Missing: []int64{2}}, err)
+
+	_, err = gl.PlanTo(3)
+	assert.Equal(t, SquashError{Version: 3, Missing: []int64{2}}, err)
+
+	report, err := gl.Status()
+	require.Nil(t, err)
+	require.Len(t, report, 3)
+	assert.Equal(t, StateSquashed, report[0].State)
+	assert.Equal(t, StateSquashedPending, report[1].State)
+	assert.Equal(t, int64(2), report[1].Version)
+	assert.Equal(t, StateApplied, report[2].State)
+}
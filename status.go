@@ -0,0 +1,152 @@
+package gloat
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// MigrationState is the state of a migration in a status report.
+type MigrationState string
+
+const (
+	// StateApplied is a migration that is in the source and is applied.
+	StateApplied MigrationState = "applied"
+
+	// StatePending is a migration that is in the source, but is not applied.
+	StatePending MigrationState = "pending"
+
+	// StateMissing is a migration that is applied, but is no longer in the
+	// source.
+	StateMissing MigrationState = "missing"
+
+	// StateDirty is a migration that failed halfway while running outside of
+	// a transaction.
+	StateDirty MigrationState = "dirty"
+
+	// StateSquashed is a migration that is applied, but was replaced in the
+	// source by a squashed migration.
+	StateSquashed MigrationState = "squashed"
+
+	// StateSquashedPending is a migration that is not applied, but was
+	// replaced in the source by a squashed migration, while some of the other
+	// migrations it replaced are applied. It has to be applied from the
+	// original migrations, see SquashError.
+	StateSquashedPending MigrationState = "squashed pending"
+)
+
+// MigrationStatus is the status of a single migration version. Path and
+// Options are unknown for missing migrations, so they are left blank.
+// OutOfOrder tells whether a pending migration is older than the latest
+// applied one.
+type MigrationStatus struct {
+	Version    int64             `json:"version"`
+	State      MigrationState    `json:"state"`
+	OutOfOrder bool              `json:"out_of_order,omitempty"`
+	Path       string            `json:"path,omitempty"`
+	AppliedAt  *time.Time        `json:"applied_at,omitempty"`
+	Reversible bool              `json:"reversible"`
+	Options    *MigrationOptions `json:"options,omitempty"`
+}
+
+// Status returns the status of every migration version in the source or the
+// store, ordered by version.
+func (c *Gloat) Status() ([]*MigrationStatus, error) {
+	return c.StatusContext(context.Background())
+}
+
+// StatusContext is like Status, but with a context.
+func (c *Gloat) StatusContext(ctx context.Context) ([]*MigrationStatus, error) {
+	appliedMigrations, err := CollectContext(ctx, c.Store)
+	if err != nil {
+		return nil, err
+	}
+
+	availableMigrations, err := CollectContext(ctx, c.source())
+	if err != nil {
+		return nil, err
+	}
+
+	dirtyMigrations, err := c.DirtyContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := map[int64]*MigrationStatus{}
+
+	for _, migration := range availableMigrations {
+		options := migration.Options
+
+		statuses[migration.Version] = &MigrationStatus{
+			Version:    migration.Version,
+			State:      StatePending,
+			Path:       migration.Path,
+			Reversible: migration.Reversible(),
+			Options:    &options,
+		}
+	}
+
+	squashed := map[int64]bool{}
+	for _, migration := range availableMigrations {
+		for _, version := range migration.Options.Squashes {
+			squashed[version] = true
+		}
+
+		if squashedApplied(migration, appliedMigrations) {
+			statuses[migration.Version].State = StateApplied
+		}
+
+		for _, version := range squashedPartially(migration, appliedMigrations) {
+			if _, ok := statuses[version]; !ok {
+				statuses[version] = &MigrationStatus{Version: version, State: StateSquashedPending}
+			}
+		}
+	}
+
+	for _, migration := range appliedMigrations {
+		appliedAt := migration.AppliedAt
+
+		status, ok := statuses[migration.Version]
+		switch {
+		case ok:
+			status.State = StateApplied
+		case squashed[migration.Version]:
+			status = &MigrationStatus{Version: migration.Version, State: StateSquashed}
+			statuses[migration.Version] = status
+		default:
+			status = &MigrationStatus{Version: migration.Version, State: StateMissing}
+			statuses[migration.Version] = status
+		}
+
+		status.AppliedAt = &appliedAt
+	}
+
+	for _, migration := range dirtyMigrations {
+		status, ok := statuses[migration.Version]
+		if !ok {
+			status = &MigrationStatus{Version: migration.Version}
+			statuses[migration.Version] = status
+		}
+
+		status.State = StateDirty
+	}
+
+	pendingMigrations := appliedMigrations.Except(availableMigrations)
+	outOfOrderMigrations, _ := outOfOrder(appliedMigrations, pendingMigrations)
+	for _, migration := range outOfOrderMigrations {
+		if status := statuses[migration.Version]; status.State == StatePending {
+			status.OutOfOrder = true
+		}
+	}
+
+	report := make([]*MigrationStatus, 0, len(statuses))
+	for _, status := range statuses {
+		report = append(report, status)
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].Version < report[j].Version
+	})
+
+	return report, nil
+}
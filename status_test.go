@@ -0,0 +1,68 @@
+package gloat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatus(t *testing.T) {
+	gl.Source = NewFileSystemSource("testdata/migrations")
+	gl.Store = &testingStore{
+		applied: Migrations{
+			&Migration{Version: 20170329154959},
+			&Migration{Version: 20160101000000},
+		},
+	}
+
+	report, err := gl.Status()
+	require.Nil(t, err)
+
+	require.Len(t, report, 5)
+
+	assert.Equal(t, int64(20160101000000), report[0].Version)
+	assert.Equal(t, StateMissing, report[0].State)
+	assert.Equal(t, "", report[0].Path)
+	assert.NotNil(t, report[0].AppliedAt)
+	assert.Nil(t, report[0].Options)
+
+	assert.Equal(t, int64(20170329154959), report[1].Version)
+	assert.Equal(t, StateApplied, report[1].State)
+	assert.Equal(t, "testdata/migrations/20170329154959_introduce_domain_model", report[1].Path)
+	assert.NotNil(t, report[1].AppliedAt)
+	assert.True(t, report[1].Reversible)
+	assert.True(t, report[1].Options.Transaction)
+
+	assert.Equal(t, int64(20170511172647), report[2].Version)
+	assert.Equal(t, StatePending, report[2].State)
+	assert.Nil(t, report[2].AppliedAt)
+	assert.False(t, report[2].Reversible)
+	assert.False(t, report[2].OutOfOrder)
+}
+
+func TestStatus_OutOfOrder(t *testing.T) {
+	gl.Source = NewFileSystemSource("testdata/migrations")
+	gl.Store = &testingStore{applied: Migrations{&Migration{Version: 20180905150724}}}
+
+	report, err := gl.Status()
+	require.Nil(t, err)
+
+	require.Len(t, report, 4)
+	assert.True(t, report[0].OutOfOrder)
+	assert.True(t, report[1].OutOfOrder)
+	assert.False(t, report[2].OutOfOrder)
+	assert.False(t, report[3].OutOfOrder)
+}
+
+func TestStatus_Dirty(t *testing.T) {
+	gl.Source = NewFileSystemSource("testdata/migrations")
+	gl.Store = &testingDirtyStore{dirty: Migrations{&Migration{Version: 20180905150724}}}
+
+	report, err := gl.Status()
+	require.Nil(t, err)
+
+	require.Len(t, report, 4)
+	assert.Equal(t, StateDirty, report[2].State)
+	assert.Equal(t, StatePending, report[3].State)
+}
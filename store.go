@@ -1,5 +1,14 @@
 package gloat
 
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
 // Store is an interface representing a place where the applied migrations are
 // recorded.
 type Store interface {
@@ -9,158 +18,629 @@ type Store interface {
 	Remove(*Migration, SQLExecer) error
 }
 
+// ContextStore is a Store that can be cancelled through a context.
+type ContextStore interface {
+	Store
+	ContextSource
+
+	InsertContext(context.Context, *Migration, SQLExecer) error
+	RemoveContext(context.Context, *Migration, SQLExecer) error
+}
+
+// InsertContext records a migration in a store with a context. If the store
+// is not a ContextStore, the context is only checked before the insert.
+func InsertContext(ctx context.Context, store Store, migration *Migration, execer SQLExecer) error {
+	if store, ok := store.(ContextStore); ok {
+		return store.InsertContext(ctx, migration, execer)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return store.Insert(migration, execer)
+}
+
+// RemoveContext removes a migration from a store with a context. If the store
+// is not a ContextStore, the context is only checked before the removal.
+func RemoveContext(ctx context.Context, store Store, migration *Migration, execer SQLExecer) error {
+	if store, ok := store.(ContextStore); ok {
+		return store.RemoveContext(ctx, migration, execer)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return store.Remove(migration, execer)
+}
+
+// DirtyStore is a Store that marks migrations as dirty while they run outside
+// of a transaction. A migration that fails halfway stays dirty, until resolved
+// by hand.
+type DirtyStore interface {
+	Store
+
+	MarkDirty(context.Context, *Migration, SQLExecer) error
+	ClearDirty(context.Context, *Migration, SQLExecer) error
+	CollectDirty(context.Context) (Migrations, error)
+}
+
 // DatabaseStore is a Store that keeps the applied migrations in a database
-// table called schema_migrations. The table is automatically created if it
-// does not exist.
+// table, called schema_migrations unless configured with WithTable. The table
+// is automatically created if it does not exist. Repeatable migrations are
+// kept by name in the schema_migrations_repeatable table.
 type DatabaseStore struct {
 	db SQLTransactor
 
+	mu      sync.Mutex
+	ensured bool
+
 	createTableStatement         string
 	createIndexStatement         string
+	addColumnStatements          []addColumnStatement
 	insertMigrationStatement     string
 	removeMigrationStatement     string
 	selectAllMigrationsStatement string
+
+	insertDirtyMigrationStatement  string
+	removeDirtyMigrationStatement  string
+	selectDirtyMigrationsStatement string
+
+	createRepeatableTableStatement      string
+	insertRepeatableMigrationStatement  string
+	removeRepeatableMigrationStatement  string
+	selectRepeatableMigrationsStatement string
 }
 
 // Insert records a migration version into the schema_migrations table.
 func (s *DatabaseStore) Insert(migration *Migration, execer SQLExecer) error {
+	return s.InsertContext(context.Background(), migration, execer)
+}
+
+// InsertContext is like Insert, but with a context.
+func (s *DatabaseStore) InsertContext(ctx context.Context, migration *Migration, execer SQLExecer) error {
 	if execer == nil {
 		execer = s.db
 	}
 
-	if err := s.ensureSchemaTableExists(); err != nil {
+	if err := s.ensureSchemaTableExists(ctx); err != nil {
 		return err
 	}
 
-	_, err := execer.Exec(s.insertMigrationStatement, migration.Version, migration.AppliedAt)
-	return err
+	for _, statement := range s.InsertStatements(migration) {
+		if _, err := sqlExecerContext(execer).ExecContext(ctx, statement.Query, statement.Args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // Remove removes a migration version from the schema_migrations table.
 func (s *DatabaseStore) Remove(migration *Migration, execer SQLExecer) error {
+	return s.RemoveContext(context.Background(), migration, execer)
+}
+
+// RemoveContext is like Remove, but with a context.
+func (s *DatabaseStore) RemoveContext(ctx context.Context, migration *Migration, execer SQLExecer) error {
 	if execer == nil {
 		execer = s.db
 	}
 
-	if err := s.ensureSchemaTableExists(); err != nil {
+	if err := s.ensureSchemaTableExists(ctx); err != nil {
 		return err
 	}
 
-	_, err := execer.Exec(s.removeMigrationStatement, migration.Version)
+	statement := s.RemoveStatements(migration)[0]
+
+	_, err := sqlExecerContext(execer).ExecContext(ctx, statement.Query, statement.Args...)
 	return err
 }
 
 // Collect builds a slice of migrations with the versions of the recorded
 // applied migrations.
-func (s *DatabaseStore) Collect() (migrations Migrations, err error) {
-	if err = s.ensureSchemaTableExists(); err != nil {
+func (s *DatabaseStore) Collect() (Migrations, error) {
+	return s.CollectContext(context.Background())
+}
+
+// CollectContext is like Collect, but with a context. Dirty migrations are
+// not collected.
+func (s *DatabaseStore) CollectContext(ctx context.Context) (Migrations, error) {
+	return s.collect(ctx, s.selectAllMigrationsStatement, false)
+}
+
+// CollectRepeatable builds a slice of the repeatable migrations, as they were
+// last applied.
+func (s *DatabaseStore) CollectRepeatable(ctx context.Context) (Migrations, error) {
+	return s.collect(ctx, s.selectRepeatableMigrationsStatement, true)
+}
+
+// InsertStatements returns the statements Insert issues for a migration. A
+// repeatable migration replaces its previous record.
+func (s *DatabaseStore) InsertStatements(migration *Migration) []StoreStatement {
+	if migration.Repeatable {
+		return []StoreStatement{
+			{
+				Query: compactStatement(s.removeRepeatableMigrationStatement),
+				Args:  []interface{}{migration.Name},
+			},
+			{
+				Query: compactStatement(s.insertRepeatableMigrationStatement),
+				Args:  insertArgs(migration),
+			},
+		}
+	}
+
+	return []StoreStatement{{
+		Query: compactStatement(s.insertMigrationStatement),
+		Args:  insertArgs(migration),
+	}}
+}
+
+// RemoveStatements returns the statements Remove issues for a migration.
+func (s *DatabaseStore) RemoveStatements(migration *Migration) []StoreStatement {
+	if migration.Repeatable {
+		return []StoreStatement{{
+			Query: compactStatement(s.removeRepeatableMigrationStatement),
+			Args:  []interface{}{migration.Name},
+		}}
+	}
+
+	return []StoreStatement{{
+		Query: compactStatement(s.removeMigrationStatement),
+		Args:  []interface{}{migration.Version},
+	}}
+}
+
+// MarkDirty records a migration as dirty, before it is run outside of a
+// transaction. The applied record of the migration, if any, is replaced.
+func (s *DatabaseStore) MarkDirty(ctx context.Context, migration *Migration, execer SQLExecer) error {
+	if execer == nil {
+		execer = s.db
+	}
+
+	if err := s.ensureSchemaTableExists(ctx); err != nil {
+		return err
+	}
+
+	if _, err := sqlExecerContext(execer).ExecContext(ctx, s.removeMigrationStatement, migration.Version); err != nil {
+		return err
+	}
+
+	_, err := sqlExecerContext(execer).ExecContext(ctx, s.insertDirtyMigrationStatement, insertArgs(migration)...)
+	return err
+}
+
+// ClearDirty removes the dirty record of a migration.
+func (s *DatabaseStore) ClearDirty(ctx context.Context, migration *Migration, execer SQLExecer) error {
+	if execer == nil {
+		execer = s.db
+	}
+
+	if err := s.ensureSchemaTableExists(ctx); err != nil {
+		return err
+	}
+
+	_, err := sqlExecerContext(execer).ExecContext(ctx, s.removeDirtyMigrationStatement, migration.Version)
+	return err
+}
+
+// CollectDirty builds a slice of the migrations recorded as dirty.
+func (s *DatabaseStore) CollectDirty(ctx context.Context) (Migrations, error) {
+	return s.collect(ctx, s.selectDirtyMigrationsStatement, false)
+}
+
+func (s *DatabaseStore) collect(ctx context.Context, statement string, repeatable bool) (migrations Migrations, err error) {
+	if err = s.ensureSchemaTableExists(ctx); err != nil {
 		return
 	}
 
-	rows, err := s.db.Query(s.selectAllMigrationsStatement)
+	rows, err := sqlExecerContext(s.db).QueryContext(ctx, statement)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		migration := &Migration{}
-		if err = rows.Scan(&migration.Version, &migration.AppliedAt); err != nil {
+		var (
+			checksum, host, appliedBy, gloatVersion, tag sql.NullString
+			durationMs                                   sql.NullInt64
+		)
+
+		migration := &Migration{Repeatable: repeatable}
+
+		var key interface{} = &migration.Version
+		if repeatable {
+			key = &migration.Name
+		}
+
+		if err = rows.Scan(key, &migration.AppliedAt, &checksum, &durationMs, &host, &appliedBy, &gloatVersion, &tag); err != nil {
 			return
 		}
+		migration.Checksum = checksum.String
+		migration.Duration = time.Duration(durationMs.Int64) * time.Millisecond
+		migration.Host = host.String
+		migration.AppliedBy = appliedBy.String
+		migration.GloatVersion = gloatVersion.String
+		migration.Tag = tag.String
 
 		migrations = append(migrations, migration)
 	}
 
+	err = rows.Err()
+
 	return
 }
 
-func (s *DatabaseStore) ensureSchemaTableExists() error {
-	if _, err := s.db.Exec(s.createTableStatement); err != nil {
+// ensureSchemaTableExists creates and upgrades the table once per store. It
+// always runs on the store database, never on the transaction of a migration,
+// as DDL statements commit the running transaction in some databases.
+func (s *DatabaseStore) ensureSchemaTableExists(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ensured {
+		return nil
+	}
+
+	execer := sqlExecerContext(s.db)
+
+	if _, err := execer.ExecContext(ctx, s.createTableStatement); err != nil {
+		return err
+	}
+
+	if _, err := execer.ExecContext(ctx, s.createIndexStatement); err != nil {
 		return err
 	}
 
-	if _, err := s.db.Exec(s.createIndexStatement); err != nil {
+	if _, err := execer.ExecContext(ctx, s.createRepeatableTableStatement); err != nil {
 		return err
 	}
 
+	// Tables created by previous versions lack the newer columns. Add them in
+	// place, so existing installs keep their applied migrations.
+	for _, add := range s.addColumnStatements {
+		rows, err := execer.QueryContext(ctx, add.probeStatement)
+		if err == nil {
+			rows.Close()
+			continue
+		}
+
+		if _, err := execer.ExecContext(ctx, add.statement); err != nil {
+			return err
+		}
+	}
+
+	s.ensured = true
 	return nil
 }
 
+// insertArgs are the arguments of the insert statements for a migration. A
+// repeatable migration is recorded by name, instead of version.
+func insertArgs(migration *Migration) []interface{} {
+	var key interface{} = migration.Version
+	if migration.Repeatable {
+		key = migration.Name
+	}
+
+	return []interface{}{
+		key,
+		migration.AppliedAt,
+		migration.Checksum,
+		migration.Duration.Milliseconds(),
+		migration.Host,
+		migration.AppliedBy,
+		migration.GloatVersion,
+		migration.Tag,
+	}
+}
+
+// addColumnStatement adds a column to the schema_migrations table, if the
+// probe statement selecting it fails.
+type addColumnStatement struct {
+	probeStatement string
+	statement      string
+}
+
+// TableOption configures the table of a DatabaseStore or a TableLocker.
+type TableOption func(*tableOptions)
+
+// WithTable sets the name of the migrations table. It defaults to
+// schema_migrations.
+func WithTable(name string) TableOption {
+	return func(o *tableOptions) { o.table = name }
+}
+
+// WithSchema sets the schema of the migrations table. That is the schema in
+// PostgreSQL, the database in MySQL and the attached database in SQLite3. It
+// defaults to the one the connection uses.
+func WithSchema(name string) TableOption {
+	return func(o *tableOptions) { o.schema = name }
+}
+
+type tableOptions struct {
+	table  string
+	schema string
+}
+
+func newTableOptions(options []TableOption) tableOptions {
+	o := tableOptions{table: "schema_migrations"}
+	for _, option := range options {
+		option(&o)
+	}
+
+	return o
+}
+
+// name returns the unquoted, schema qualified name of a table derived from
+// the migrations table, e.g. the index or the lock table.
+func (o tableOptions) name(suffix string) string {
+	if o.schema == "" {
+		return o.table + suffix
+	}
+
+	return o.schema + "." + o.table + suffix
+}
+
+// qualify returns the quoted, schema qualified name of a table derived from
+// the migrations table.
+func (o tableOptions) qualify(quote func(string) string, suffix string) string {
+	if o.schema == "" {
+		return quote(o.table + suffix)
+	}
+
+	return quote(o.schema) + "." + quote(o.table+suffix)
+}
+
+func quoteDoubleQuotes(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func quoteBackticks(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// addColumns builds the statements adding columns to a table created by a
+// previous version. The column name is the first word of its definition.
+func addColumns(table string, definitions ...string) []addColumnStatement {
+	statements := make([]addColumnStatement, 0, len(definitions))
+	for _, definition := range definitions {
+		column := strings.Fields(definition)[0]
+
+		statements = append(statements, addColumnStatement{
+			probeStatement: fmt.Sprintf(`SELECT %s FROM %s WHERE 1=0`, column, table),
+			statement:      fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s`, table, definition),
+		})
+	}
+
+	return statements
+}
+
+// metadataColumns are the columns recording who, where and how long ran a
+// migration.
+var metadataColumns = []string{
+	"duration_ms BIGINT",
+	"host VARCHAR(255)",
+	"applied_by VARCHAR(255)",
+	"gloat_version VARCHAR(64)",
+	"tag VARCHAR(255)",
+}
+
+// repeatableColumns are the columns of the repeatable migrations table after
+// the name.
+const repeatableColumns = `
+				applied_at %s,
+				checksum VARCHAR(64),
+				duration_ms BIGINT,
+				host VARCHAR(255),
+				applied_by VARCHAR(255),
+				gloat_version VARCHAR(64),
+				tag VARCHAR(255)`
+
 // NewPostgreSQLStore creates a Store for PostgreSQL.
-func NewPostgreSQLStore(db SQLTransactor) Store {
+func NewPostgreSQLStore(db SQLTransactor, options ...TableOption) Store {
+	o := newTableOptions(options)
+	table := o.qualify(quoteDoubleQuotes, "")
+	index := quoteDoubleQuotes(o.table + "_applied_at")
+	repeatableTable := o.qualify(quoteDoubleQuotes, "_repeatable")
+
 	return &DatabaseStore{
 		db: db,
-		createTableStatement: `
-			CREATE TABLE IF NOT EXISTS schema_migrations (
+		createTableStatement: fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s (
 				version BIGINT PRIMARY KEY NOT NULL,
-				applied_at timestamp without time zone default (now() at time zone 'utc')
-			)`,
-		createIndexStatement: `
-			CREATE INDEX IF NOT EXISTS schema_migrations_applied_at
-			ON schema_migrations (applied_at)
-			`,
-		insertMigrationStatement: `
-			INSERT INTO schema_migrations (version, applied_at)
-			VALUES ($1, $2)`,
-		removeMigrationStatement: `
-			DELETE FROM schema_migrations
-			WHERE version=$1`,
-		selectAllMigrationsStatement: `
-			SELECT version, applied_at
-			FROM schema_migrations
-			ORDER BY applied_at DESC, version DESC`,
+				applied_at timestamp without time zone default (now() at time zone 'utc'),
+				checksum VARCHAR(64),
+				dirty BOOLEAN NOT NULL DEFAULT FALSE,
+				duration_ms BIGINT,
+				host VARCHAR(255),
+				applied_by VARCHAR(255),
+				gloat_version VARCHAR(64),
+				tag VARCHAR(255)
+			)`, table),
+		createIndexStatement: fmt.Sprintf(`
+			CREATE INDEX IF NOT EXISTS %s
+			ON %s (applied_at)
+			`, index, table),
+		addColumnStatements: addColumns(table, append([]string{
+			"checksum VARCHAR(64)",
+			"dirty BOOLEAN NOT NULL DEFAULT FALSE",
+		}, metadataColumns...)...),
+		insertMigrationStatement: fmt.Sprintf(`
+			INSERT INTO %s (version, applied_at, checksum, duration_ms, host, applied_by, gloat_version, tag)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, table),
+		removeMigrationStatement: fmt.Sprintf(`
+			DELETE FROM %s
+			WHERE version=$1`, table),
+		selectAllMigrationsStatement: fmt.Sprintf(`
+			SELECT version, applied_at, checksum, duration_ms, host, applied_by, gloat_version, tag
+			FROM %s
+			WHERE dirty = FALSE
+			ORDER BY applied_at DESC, version DESC`, table),
+		insertDirtyMigrationStatement: fmt.Sprintf(`
+			INSERT INTO %s (version, applied_at, checksum, duration_ms, host, applied_by, gloat_version, tag, dirty)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, TRUE)`, table),
+		removeDirtyMigrationStatement: fmt.Sprintf(`
+			DELETE FROM %s
+			WHERE version=$1 AND dirty = TRUE`, table),
+		selectDirtyMigrationsStatement: fmt.Sprintf(`
+			SELECT version, applied_at, checksum, duration_ms, host, applied_by, gloat_version, tag
+			FROM %s
+			WHERE dirty = TRUE
+			ORDER BY applied_at DESC, version DESC`, table),
+		createRepeatableTableStatement: fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s (
+				name VARCHAR(255) PRIMARY KEY NOT NULL,`+repeatableColumns+`
+			)`, repeatableTable, "timestamp without time zone"),
+		insertRepeatableMigrationStatement: fmt.Sprintf(`
+			INSERT INTO %s (name, applied_at, checksum, duration_ms, host, applied_by, gloat_version, tag)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, repeatableTable),
+		removeRepeatableMigrationStatement: fmt.Sprintf(`
+			DELETE FROM %s
+			WHERE name=$1`, repeatableTable),
+		selectRepeatableMigrationsStatement: fmt.Sprintf(`
+			SELECT name, applied_at, checksum, duration_ms, host, applied_by, gloat_version, tag
+			FROM %s
+			ORDER BY name`, repeatableTable),
 	}
 }
 
 // NewMySQLStore creates a Store for MySQL.
-func NewMySQLStore(db SQLTransactor) Store {
+func NewMySQLStore(db SQLTransactor, options ...TableOption) Store {
+	o := newTableOptions(options)
+	table := o.qualify(quoteBackticks, "")
+	index := quoteBackticks(o.table + "_applied_at")
+	repeatableTable := o.qualify(quoteBackticks, "_repeatable")
+
 	return &DatabaseStore{
 		db: db,
-		createTableStatement: `
-			CREATE TABLE IF NOT EXISTS schema_migrations (
+		createTableStatement: fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s (
 				version BIGINT PRIMARY KEY NOT NULL,
-				applied_at TIMESTAMP DEFAULT UTC_TIMESTAMP
-			)`,
-		createIndexStatement: `
-			CREATE INDEX IF NOT EXISTS schema_migrations_applied_at
-			ON schema_migrations (applied_at)
-			`,
-		insertMigrationStatement: `
-			INSERT INTO schema_migrations (version, applied_at)
-			VALUES (?, ?)`,
-		removeMigrationStatement: `
-			DELETE FROM schema_migrations
-			WHERE version=?`,
-		selectAllMigrationsStatement: `
-			SELECT version, applied_at
-			FROM schema_migrations
-			ORDER BY applied_at DESC, version DESC`,
+				applied_at TIMESTAMP DEFAULT UTC_TIMESTAMP,
+				checksum VARCHAR(64),
+				dirty BOOLEAN NOT NULL DEFAULT FALSE,
+				duration_ms BIGINT,
+				host VARCHAR(255),
+				applied_by VARCHAR(255),
+				gloat_version VARCHAR(64),
+				tag VARCHAR(255)
+			)`, table),
+		createIndexStatement: fmt.Sprintf(`
+			CREATE INDEX IF NOT EXISTS %s
+			ON %s (applied_at)
+			`, index, table),
+		addColumnStatements: addColumns(table, append([]string{
+			"checksum VARCHAR(64)",
+			"dirty BOOLEAN NOT NULL DEFAULT FALSE",
+		}, metadataColumns...)...),
+		insertMigrationStatement: fmt.Sprintf(`
+			INSERT INTO %s (version, applied_at, checksum, duration_ms, host, applied_by, gloat_version, tag)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, table),
+		removeMigrationStatement: fmt.Sprintf(`
+			DELETE FROM %s
+			WHERE version=?`, table),
+		selectAllMigrationsStatement: fmt.Sprintf(`
+			SELECT version, applied_at, checksum, duration_ms, host, applied_by, gloat_version, tag
+			FROM %s
+			WHERE dirty = FALSE
+			ORDER BY applied_at DESC, version DESC`, table),
+		insertDirtyMigrationStatement: fmt.Sprintf(`
+			INSERT INTO %s (version, applied_at, checksum, duration_ms, host, applied_by, gloat_version, tag, dirty)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, TRUE)`, table),
+		removeDirtyMigrationStatement: fmt.Sprintf(`
+			DELETE FROM %s
+			WHERE version=? AND dirty = TRUE`, table),
+		selectDirtyMigrationsStatement: fmt.Sprintf(`
+			SELECT version, applied_at, checksum, duration_ms, host, applied_by, gloat_version, tag
+			FROM %s
+			WHERE dirty = TRUE
+			ORDER BY applied_at DESC, version DESC`, table),
+		createRepeatableTableStatement: fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s (
+				name VARCHAR(255) PRIMARY KEY NOT NULL,`+repeatableColumns+`
+			)`, repeatableTable, "TIMESTAMP NULL"),
+		insertRepeatableMigrationStatement: fmt.Sprintf(`
+			INSERT INTO %s (name, applied_at, checksum, duration_ms, host, applied_by, gloat_version, tag)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, repeatableTable),
+		removeRepeatableMigrationStatement: fmt.Sprintf(`
+			DELETE FROM %s
+			WHERE name=?`, repeatableTable),
+		selectRepeatableMigrationsStatement: fmt.Sprintf(`
+			SELECT name, applied_at, checksum, duration_ms, host, applied_by, gloat_version, tag
+			FROM %s
+			ORDER BY name`, repeatableTable),
 	}
 }
 
 // NewSQLite3Store creates a Store for SQLite3.
-func NewSQLite3Store(db SQLTransactor) Store {
+func NewSQLite3Store(db SQLTransactor, options ...TableOption) Store {
+	o := newTableOptions(options)
+	table := o.qualify(quoteDoubleQuotes, "")
+
+	// SQLite3 qualifies the index name with the schema, not the table.
+	index := o.qualify(quoteDoubleQuotes, "_applied_at")
+	indexTable := quoteDoubleQuotes(o.table)
+	repeatableTable := o.qualify(quoteDoubleQuotes, "_repeatable")
+
 	return &DatabaseStore{
 		db: db,
-		createTableStatement: `
-			CREATE TABLE IF NOT EXISTS schema_migrations (
-				version BIGINT PRIMARY KEY NOT NULL
-				applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
-			)`,
-		insertMigrationStatement: `
-			INSERT INTO schema_migrations (version, applied_at)
-			VALUES (?, ?)`,
-		createIndexStatement: `
-			CREATE INDEX IF NOT EXISTS schema_migrations_applied_at
-			ON schema_migrations (applied_at)
-			`,
-		removeMigrationStatement: `
-			DELETE FROM schema_migrations
-			WHERE version=?`,
-		selectAllMigrationsStatement: `
-			SELECT version, applied_at
-			FROM schema_migrations
-			ORDER BY applied_at DESC, version DESC`,
+		createTableStatement: fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s (
+				version BIGINT PRIMARY KEY NOT NULL,
+				applied_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				checksum VARCHAR(64),
+				dirty BOOLEAN NOT NULL DEFAULT 0,
+				duration_ms BIGINT,
+				host VARCHAR(255),
+				applied_by VARCHAR(255),
+				gloat_version VARCHAR(64),
+				tag VARCHAR(255)
+			)`, table),
+		insertMigrationStatement: fmt.Sprintf(`
+			INSERT INTO %s (version, applied_at, checksum, duration_ms, host, applied_by, gloat_version, tag)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, table),
+		createIndexStatement: fmt.Sprintf(`
+			CREATE INDEX IF NOT EXISTS %s
+			ON %s (applied_at)
+			`, index, indexTable),
+		addColumnStatements: addColumns(table, append([]string{
+			"checksum VARCHAR(64)",
+			"dirty BOOLEAN NOT NULL DEFAULT 0",
+		}, metadataColumns...)...),
+		removeMigrationStatement: fmt.Sprintf(`
+			DELETE FROM %s
+			WHERE version=?`, table),
+		selectAllMigrationsStatement: fmt.Sprintf(`
+			SELECT version, applied_at, checksum, duration_ms, host, applied_by, gloat_version, tag
+			FROM %s
+			WHERE dirty = 0
+			ORDER BY applied_at DESC, version DESC`, table),
+		insertDirtyMigrationStatement: fmt.Sprintf(`
+			INSERT INTO %s (version, applied_at, checksum, duration_ms, host, applied_by, gloat_version, tag, dirty)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1)`, table),
+		removeDirtyMigrationStatement: fmt.Sprintf(`
+			DELETE FROM %s
+			WHERE version=? AND dirty = 1`, table),
+		selectDirtyMigrationsStatement: fmt.Sprintf(`
+			SELECT version, applied_at, checksum, duration_ms, host, applied_by, gloat_version, tag
+			FROM %s
+			WHERE dirty = 1
+			ORDER BY applied_at DESC, version DESC`, table),
+		createRepeatableTableStatement: fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s (
+				name VARCHAR(255) PRIMARY KEY NOT NULL,`+repeatableColumns+`
+			)`, repeatableTable, "DATETIME"),
+		insertRepeatableMigrationStatement: fmt.Sprintf(`
+			INSERT INTO %s (name, applied_at, checksum, duration_ms, host, applied_by, gloat_version, tag)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, repeatableTable),
+		removeRepeatableMigrationStatement: fmt.Sprintf(`
+			DELETE FROM %s
+			WHERE name=?`, repeatableTable),
+		selectRepeatableMigrationsStatement: fmt.Sprintf(`
+			SELECT name, applied_at, checksum, duration_ms, host, applied_by, gloat_version, tag
+			FROM %s
+			ORDER BY name`, repeatableTable),
 	}
 }
@@ -1,12 +1,15 @@
 package gloat
 
 import (
+	"context"
 	"database/sql"
 	"io/ioutil"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDatabaseStore_Insert(t *testing.T) {
@@ -80,3 +83,196 @@ func TestDatabaseStore_Collect(t *testing.T) {
 		assert.Equal(t, migrations[0].Version, expectedMigrations[0].Version)
 	})
 }
+
+func TestDatabaseStore_Collect_Checksum(t *testing.T) {
+	td := filepath.Join(dbSrc, "20170329154959_introduce_domain_model")
+
+	migration, err := MigrationFromBytes(td, ioutil.ReadFile)
+	assert.Nil(t, err)
+
+	dbStore, err := databaseStoreFactory(dbDriver, db)
+	assert.Nil(t, err)
+
+	cleanState(func() {
+		err := dbStore.Insert(migration, nil)
+		assert.Nil(t, err)
+
+		migrations, err := dbStore.Collect()
+		assert.Nil(t, err)
+
+		assert.Len(t, migrations, 1)
+		assert.Equal(t, migration.Checksum, migrations[0].Checksum)
+	})
+}
+
+func TestDatabaseStore_UpgradesExistingTable(t *testing.T) {
+	dbStore, err := databaseStoreFactory(dbDriver, db)
+	assert.Nil(t, err)
+
+	cleanState(func() {
+		_, err := db.Exec(`
+			CREATE TABLE schema_migrations (
+				version BIGINT PRIMARY KEY NOT NULL,
+				applied_at TIMESTAMP
+			)`)
+		assert.Nil(t, err)
+
+		_, err = db.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (20170329154959, CURRENT_TIMESTAMP)`)
+		assert.Nil(t, err)
+
+		migrations, err := dbStore.Collect()
+		assert.Nil(t, err)
+
+		require.Len(t, migrations, 1)
+		assert.Equal(t, int64(20170329154959), migrations[0].Version)
+		assert.Equal(t, "", migrations[0].Checksum)
+
+		_, err = db.Exec(`SELECT checksum, duration_ms, host, applied_by, gloat_version, tag FROM schema_migrations`)
+		assert.Nil(t, err)
+	})
+}
+
+func TestDatabaseStore_Collect_Metadata(t *testing.T) {
+	dbStore, err := databaseStoreFactory(dbDriver, db)
+	assert.Nil(t, err)
+
+	migration := &Migration{
+		Version:      20170329154959,
+		AppliedAt:    time.Now().UTC(),
+		Duration:     1500 * time.Millisecond,
+		Host:         "deploy-1",
+		AppliedBy:    "deployer",
+		GloatVersion: "v1.2.3",
+		Tag:          "release-42",
+	}
+
+	cleanState(func() {
+		err := dbStore.Insert(migration, nil)
+		assert.Nil(t, err)
+
+		migrations, err := dbStore.Collect()
+		assert.Nil(t, err)
+
+		require.Len(t, migrations, 1)
+		assert.Equal(t, 1500*time.Millisecond, migrations[0].Duration)
+		assert.Equal(t, "deploy-1", migrations[0].Host)
+		assert.Equal(t, "deployer", migrations[0].AppliedBy)
+		assert.Equal(t, "v1.2.3", migrations[0].GloatVersion)
+		assert.Equal(t, "release-42", migrations[0].Tag)
+	})
+}
+
+func TestDatabaseStore_MarkDirty(t *testing.T) {
+	td := filepath.Join(dbSrc, "20170329154959_introduce_domain_model")
+
+	migration, err := MigrationFromBytes(td, ioutil.ReadFile)
+	assert.Nil(t, err)
+
+	dbStore, err := databaseStoreFactory(dbDriver, db)
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	cleanState(func() {
+		err := dbStore.Insert(migration, nil)
+		assert.Nil(t, err)
+
+		err = dbStore.(DirtyStore).MarkDirty(ctx, migration, nil)
+		assert.Nil(t, err)
+
+		migrations, err := dbStore.Collect()
+		assert.Nil(t, err)
+		assert.Len(t, migrations, 0)
+
+		dirty, err := dbStore.(DirtyStore).CollectDirty(ctx)
+		assert.Nil(t, err)
+		require.Len(t, dirty, 1)
+		assert.Equal(t, int64(20170329154959), dirty[0].Version)
+
+		err = dbStore.(DirtyStore).ClearDirty(ctx, migration, nil)
+		assert.Nil(t, err)
+
+		dirty, err = dbStore.(DirtyStore).CollectDirty(ctx)
+		assert.Nil(t, err)
+		assert.Len(t, dirty, 0)
+	})
+}
+
+func TestDatabaseStore_Repeatable(t *testing.T) {
+	migration := &Migration{Name: "active_users_view", Repeatable: true, Checksum: "first"}
+
+	dbStore, err := databaseStoreFactory(dbDriver, db)
+	assert.Nil(t, err)
+
+	cleanState(func() {
+		err := dbStore.Insert(migration, nil)
+		require.Nil(t, err)
+
+		migration.Checksum = "second"
+
+		err = dbStore.Insert(migration, nil)
+		require.Nil(t, err)
+
+		migrations, err := dbStore.(RepeatableSource).CollectRepeatable(context.Background())
+		require.Nil(t, err)
+		require.Len(t, migrations, 1)
+		assert.Equal(t, "active_users_view", migrations[0].Name)
+		assert.Equal(t, "second", migrations[0].Checksum)
+		assert.True(t, migrations[0].Repeatable)
+
+		// Repeatable migrations are not versioned ones.
+		migrations, err = dbStore.Collect()
+		require.Nil(t, err)
+		assert.Len(t, migrations, 0)
+
+		err = dbStore.Remove(migration, nil)
+		require.Nil(t, err)
+
+		migrations, err = dbStore.(RepeatableSource).CollectRepeatable(context.Background())
+		require.Nil(t, err)
+		assert.Len(t, migrations, 0)
+	})
+}
+
+func TestDatabaseStore_WithTable(t *testing.T) {
+	td := filepath.Join(dbSrc, "20170329154959_introduce_domain_model")
+
+	migration, err := MigrationFromBytes(td, ioutil.ReadFile)
+	assert.Nil(t, err)
+
+	dbStore, err := databaseStoreFactory(dbDriver, db, WithTable("billing_migrations"))
+	assert.Nil(t, err)
+
+	cleanState(func() {
+		defer db.Exec(`DROP TABLE IF EXISTS billing_migrations`)
+		defer db.Exec(`DROP TABLE IF EXISTS billing_migrations_repeatable`)
+
+		err := dbStore.Insert(migration, nil)
+		assert.Nil(t, err)
+
+		var version int64
+		err = db.QueryRow(`SELECT version FROM billing_migrations`).Scan(&version)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(20170329154959), version)
+
+		_, err = db.Exec(`SELECT version FROM schema_migrations`)
+		assert.NotNil(t, err)
+
+		migrations, err := dbStore.Collect()
+		assert.Nil(t, err)
+		assert.Len(t, migrations, 1)
+	})
+}
+
+func TestDatabaseStore_WithSchema_Quoted(t *testing.T) {
+	store := NewPostgreSQLStore(nil, WithSchema("audit"), WithTable(`odd"name`)).(*DatabaseStore)
+	assert.Contains(t, store.insertMigrationStatement, `INSERT INTO "audit"."odd""name"`)
+	assert.Contains(t, store.createIndexStatement, `"odd""name_applied_at"`)
+
+	store = NewMySQLStore(nil, WithSchema("audit"), WithTable("odd`name")).(*DatabaseStore)
+	assert.Contains(t, store.removeMigrationStatement, "DELETE FROM `audit`.`odd``name`")
+
+	store = NewSQLite3Store(nil, WithSchema("audit")).(*DatabaseStore)
+	assert.Contains(t, store.createIndexStatement, `"audit"."schema_migrations_applied_at"`)
+	assert.Contains(t, store.createIndexStatement, `ON "schema_migrations" (applied_at)`)
+}
@@ -0,0 +1,124 @@
+package gloat
+
+import (
+	"bytes"
+	"context"
+	"text/template"
+)
+
+// VarsProvider provides the variables the migration templates are rendered
+// with, e.g. out of a secrets manager.
+type VarsProvider interface {
+	Vars() (map[string]string, error)
+}
+
+// Vars is a VarsProvider of a fixed set of variables.
+type Vars map[string]string
+
+// Vars returns the variables themselves.
+func (v Vars) Vars() (map[string]string, error) {
+	return v, nil
+}
+
+// TemplateSource renders the UP and DOWN content of the migrations of a
+// Source as text/template templates, e.g. CREATE SCHEMA {{.schema}};. The
+// rendered content is what is executed and checksummed. A variable missing
+// from the VarsProvider is an error.
+type TemplateSource struct {
+	Source Source
+	Vars   VarsProvider
+}
+
+// Collect renders the migrations of the source.
+func (s *TemplateSource) Collect() (Migrations, error) {
+	return s.CollectContext(context.Background())
+}
+
+// CollectContext is like Collect, but with a context.
+func (s *TemplateSource) CollectContext(ctx context.Context) (Migrations, error) {
+	migrations, err := CollectContext(ctx, s.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.render(migrations)
+}
+
+// CollectRepeatable renders the repeatable migrations of the source.
+func (s *TemplateSource) CollectRepeatable(ctx context.Context) (Migrations, error) {
+	migrations, err := CollectRepeatable(ctx, s.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.render(migrations)
+}
+
+func (s *TemplateSource) render(migrations Migrations) (Migrations, error) {
+	vars, err := s.Vars.Vars()
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := make(Migrations, 0, len(migrations))
+	for _, migration := range migrations {
+		migration, err := RenderMigration(migration, vars)
+		if err != nil {
+			return nil, err
+		}
+
+		rendered = append(rendered, migration)
+	}
+
+	return rendered, nil
+}
+
+// NewTemplateSource creates a source that renders the migrations of another
+// source with the given variables.
+func NewTemplateSource(source Source, vars VarsProvider) Source {
+	return &TemplateSource{Source: source, Vars: vars}
+}
+
+// RenderMigration returns a copy of a migration with its UP and DOWN content
+// rendered as text/template templates with the given variables, and its
+// checksum updated. Go migrations are returned as they are.
+func RenderMigration(migration *Migration, vars map[string]string) (*Migration, error) {
+	if migration.UpFunc != nil || migration.DownFunc != nil {
+		return migration, nil
+	}
+
+	upSQL, err := renderSQL(migration.Path, migration.UpSQL, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	downSQL, err := renderSQL(migration.Path, migration.DownSQL, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := *migration
+	rendered.UpSQL = upSQL
+	rendered.DownSQL = downSQL
+	rendered.Checksum = Checksum(upSQL, downSQL)
+
+	return &rendered, nil
+}
+
+func renderSQL(name string, content []byte, vars map[string]string) ([]byte, error) {
+	if len(content) == 0 {
+		return content, nil
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, vars); err != nil {
+		return nil, err
+	}
+
+	return rendered.Bytes(), nil
+}
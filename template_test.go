@@ -0,0 +1,89 @@
+package gloat
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingVars struct{}
+
+func (failingVars) Vars() (map[string]string, error) {
+	return nil, errors.New("vars unavailable")
+}
+
+func TestRenderMigration(t *testing.T) {
+	migration := &Migration{
+		UpSQL:   []byte("CREATE TABLE {{.schema}}.users () TABLESPACE {{.tablespace}};"),
+		DownSQL: []byte("DROP TABLE {{.schema}}.users;"),
+		Path:    "migrations/20170329154959_introduce_domain_model",
+		Version: 20170329154959,
+	}
+
+	rendered, err := RenderMigration(migration, Vars{"schema": "tenant", "tablespace": "fast"})
+	require.Nil(t, err)
+
+	assert.Equal(t, []byte("CREATE TABLE tenant.users () TABLESPACE fast;"), rendered.UpSQL)
+	assert.Equal(t, []byte("DROP TABLE tenant.users;"), rendered.DownSQL)
+	assert.Equal(t, Checksum(rendered.UpSQL, rendered.DownSQL), rendered.Checksum)
+	assert.Equal(t, migration.Version, rendered.Version)
+
+	assert.Equal(t, []byte("DROP TABLE {{.schema}}.users;"), migration.DownSQL)
+}
+
+func TestRenderMigration_Undefined(t *testing.T) {
+	migration := &Migration{
+		UpSQL: []byte("CREATE TABLE {{.schema}}.users ();"),
+		Path:  "migrations/20170329154959_introduce_domain_model",
+	}
+
+	_, err := RenderMigration(migration, Vars{"tablespace": "fast"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "schema")
+}
+
+func TestTemplateSourceCollect(t *testing.T) {
+	fsys := fstest.MapFS{
+		"20170329154959_introduce_domain_model/up.sql": {Data: []byte("CREATE SCHEMA {{.schema}};")},
+		"R_users_view/up.sql":                          {Data: []byte("CREATE VIEW {{.schema}}.users_view AS SELECT 1;")},
+	}
+
+	src := NewTemplateSource(NewFSSource(fsys, ""), Vars{"schema": "tenant"})
+
+	migrations, err := src.Collect()
+	require.Nil(t, err)
+	require.Len(t, migrations, 1)
+	assert.Equal(t, []byte("CREATE SCHEMA tenant;"), migrations[0].UpSQL)
+
+	migrations, err = CollectRepeatable(context.Background(), src)
+	require.Nil(t, err)
+	require.Len(t, migrations, 1)
+	assert.Equal(t, []byte("CREATE VIEW tenant.users_view AS SELECT 1;"), migrations[0].UpSQL)
+
+	_, err = NewTemplateSource(NewFSSource(fsys, ""), failingVars{}).Collect()
+	assert.EqualError(t, err, "vars unavailable")
+}
+
+func TestPlanUp_Vars(t *testing.T) {
+	defer func() { gl.Vars = nil }()
+
+	gl.Source = NewFSSource(fstest.MapFS{
+		"20170329154959_introduce_domain_model/up.sql": {Data: []byte("CREATE TABLE {{.table}} (id INTEGER);")},
+	}, "")
+	gl.Store = &testingStore{}
+	gl.Vars = Vars{"table": "users"}
+
+	plan, err := gl.PlanUp()
+	require.Nil(t, err)
+	require.Len(t, plan, 1)
+	assert.Equal(t, []byte("CREATE TABLE users (id INTEGER);"), plan[0].Migration.UpSQL)
+
+	gl.Vars = Vars{}
+
+	_, err = gl.PlanUp()
+	assert.Error(t, err)
+}
@@ -0,0 +1,125 @@
+package gloat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TenantSetup builds the Gloat of a single target of a TenantRunner, with its
+// own Store. The release function, if any, is called once the target is
+// migrated, e.g. to close its database connection.
+type TenantSetup func(ctx context.Context, target string) (gl *Gloat, release func(), err error)
+
+// TenantResult is the outcome of migrating a single target.
+type TenantResult struct {
+	Target   string
+	Applied  Migrations
+	Duration time.Duration
+	Err      error
+}
+
+// TenantError is the error returned when some of the targets of a
+// TenantRunner failed to migrate. Their errors are in the TenantResult of
+// every target.
+type TenantError struct {
+	Targets []string
+}
+
+// Error implements the error interface.
+func (err TenantError) Error() string {
+	return fmt.Sprintf("migrating %d targets failed: %s", len(err.Targets), strings.Join(err.Targets, ", "))
+}
+
+// TenantRunner brings several targets to the latest version, e.g. every
+// schema of a database with a schema per customer or every database of a
+// list. All of the targets are migrated, even if some of them fail.
+type TenantRunner struct {
+	// Targets are the schema names, connection URLs or whatever identifies a
+	// target to Setup.
+	Targets []string
+
+	// Setup builds the Gloat of a target. The Gloats usually share the same
+	// Source, but not the Store.
+	Setup TenantSetup
+
+	// Parallel is how many targets are migrated at once. They are migrated
+	// one after another, if it is less than 2.
+	Parallel int
+}
+
+// Migrate applies the unapplied migrations of every target, see
+// Gloat.Migrate. It returns a result for every target, in the order of
+// Targets, and a TenantError if any of them failed.
+func (r *TenantRunner) Migrate() ([]TenantResult, error) {
+	return r.MigrateContext(context.Background())
+}
+
+// MigrateContext is like Migrate, but with a context. Cancelling the context
+// stops the running targets and fails the ones not started yet.
+func (r *TenantRunner) MigrateContext(ctx context.Context) ([]TenantResult, error) {
+	parallel := r.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	var (
+		results = make([]TenantResult, len(r.Targets))
+		slots   = make(chan struct{}, parallel)
+		wg      sync.WaitGroup
+	)
+
+	for i, target := range r.Targets {
+		results[i].Target = target
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *TenantResult) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			start := time.Now()
+			result.Applied, result.Err = r.migrate(ctx, result.Target)
+			result.Duration = time.Since(start)
+		}(&results[i])
+	}
+
+	wg.Wait()
+
+	var err TenantError
+	for _, result := range results {
+		if result.Err != nil {
+			err.Targets = append(err.Targets, result.Target)
+		}
+	}
+
+	if len(err.Targets) != 0 {
+		return results, err
+	}
+
+	return results, nil
+}
+
+func (r *TenantRunner) migrate(ctx context.Context, target string) (Migrations, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	gl, release, err := r.Setup(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	if release != nil {
+		defer release()
+	}
+
+	return gl.MigrateContext(ctx)
+}
@@ -0,0 +1,84 @@
+package gloat
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantRunnerMigrate(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		released []string
+	)
+
+	runner := TenantRunner{
+		Targets:  []string{"tenant_one", "tenant_two", "tenant_three"},
+		Parallel: 2,
+		Setup: func(_ context.Context, target string) (*Gloat, func(), error) {
+			if target == "tenant_two" {
+				return nil, nil, errors.New("no such schema")
+			}
+
+			var applied Migrations
+			if target == "tenant_three" {
+				applied = Migrations{&Migration{Version: 20170329154959}}
+			}
+
+			gl := &Gloat{
+				Source:   NewFileSystemSource("testdata/migrations"),
+				Store:    &testingStore{applied: applied},
+				Executor: &testingExecutor{},
+			}
+
+			release := func() {
+				mu.Lock()
+				defer mu.Unlock()
+				released = append(released, target)
+			}
+
+			return gl, release, nil
+		},
+	}
+
+	results, err := runner.Migrate()
+	assert.Equal(t, TenantError{Targets: []string{"tenant_two"}}, err)
+
+	require.Len(t, results, 3)
+	assert.Equal(t, "tenant_one", results[0].Target)
+	assert.Nil(t, results[0].Err)
+	assert.Len(t, results[0].Applied, 4)
+
+	assert.Equal(t, "tenant_two", results[1].Target)
+	assert.EqualError(t, results[1].Err, "no such schema")
+
+	assert.Nil(t, results[2].Err)
+	assert.Len(t, results[2].Applied, 3)
+
+	assert.ElementsMatch(t, []string{"tenant_one", "tenant_three"}, released)
+}
+
+func TestTenantRunnerMigrateContext_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	runner := TenantRunner{
+		Targets: []string{"tenant_one", "tenant_two"},
+		Setup: func(context.Context, string) (*Gloat, func(), error) {
+			t.Fatal("no target is set up once the context is done")
+			return nil, nil, nil
+		},
+	}
+
+	results, err := runner.MigrateContext(ctx)
+	assert.Equal(t, TenantError{Targets: []string{"tenant_one", "tenant_two"}}, err)
+
+	require.Len(t, results, 2)
+	for _, result := range results {
+		assert.Equal(t, context.Canceled, result.Err)
+	}
+}
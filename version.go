@@ -0,0 +1,50 @@
+package gloat
+
+import (
+	"os"
+	"os/user"
+	"runtime/debug"
+)
+
+const modulePath = "github.com/webedx-spark/gloat"
+
+// Version is the gloat version recorded with the applied migrations. If
+// blank, it is read from the build information of the binary. Set it with
+// -ldflags "-X github.com/webedx-spark/gloat.Version=..." otherwise.
+var Version = ""
+
+func version() string {
+	if Version != "" {
+		return Version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+
+	return ""
+}
+
+func currentHost() string {
+	host, _ := os.Hostname()
+	return host
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+
+	return os.Getenv("USER")
+}